@@ -0,0 +1,44 @@
+//go:build go1.23
+// +build go1.23
+
+package tinydom
+
+import "iter"
+
+// Children 返回一个按顺序遍历node所有子节点的迭代器,可以直接用于for node := range Children(elem).
+// 遍历前会先记下下一个兄弟节点,所以在循环中删除或者移走当前节点是安全的
+//
+// Children、Elements是函数而不是XMLNode的方法:iter.Seq只在Go 1.23及以上版本中存在,而接口的方法集不能随Go版本变化,
+// 加到XMLNode接口里会使整个库无法在旧版本的Go中编译,所以写作Children(elem)而不是elem.Children()
+func Children(node XMLNode) iter.Seq[XMLNode] {
+	return func(yield func(XMLNode) bool) {
+		if nil == node {
+			return
+		}
+
+		for child := node.FirstChild(); nil != child; {
+			next := child.Next()
+			if !yield(child) {
+				return
+			}
+			child = next
+		}
+	}
+}
+
+// Elements 返回一个按顺序遍历node中名为name的子元素的迭代器,name为空串时遍历所有子元素,规则与FirstChildElement相同
+func Elements(node XMLNode, name string) iter.Seq[XMLElement] {
+	return func(yield func(XMLElement) bool) {
+		if nil == node {
+			return
+		}
+
+		for elem := node.FirstChildElement(name); nil != elem; {
+			next := elem.NextElement(name)
+			if !yield(elem) {
+				return
+			}
+			elem = next
+		}
+	}
+}
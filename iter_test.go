@@ -0,0 +1,51 @@
+//go:build go1.23
+// +build go1.23
+
+package tinydom
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Iter_ChildrenAndElements(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/>文本<b/><!--c--><a id="2"/></root>`))
+	root := doc.FirstChildElement("root")
+
+	var values []string
+	for node := range Children(root) {
+		values = append(values, node.Value())
+	}
+	expect(t, "遍历所有子节点", "a,文本,b,c,a" == strings.Join(values, ","))
+
+	count := 0
+	for elem := range Elements(root, "a") {
+		count++
+		expect(t, "只遍历指定名字的元素", "a" == elem.Name())
+	}
+	expect(t, "元素个数", 2 == count)
+
+	count = 0
+	for range Elements(root, "") {
+		count++
+	}
+	expect(t, "遍历所有子元素", 3 == count)
+
+	// 提前结束遍历
+	for elem := range Elements(root, "") {
+		expect(t, "break之后不再继续", "a" == elem.Name())
+		break
+	}
+
+	// 遍历过程中删除当前节点
+	for node := range Children(root) {
+		if nil == node.ToElement() {
+			root.DeleteChild(node)
+		}
+	}
+	expect(t, "删除非元素节点", `<root><a/><b/><a id="2"/></root>` == NodeToString(root, PrintStream))
+
+	for range Children(nil) {
+		t.Fail()
+	}
+}
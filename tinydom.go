@@ -2,12 +2,21 @@
 package tinydom
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"container/list"
 	"encoding/xml"
 	"errors"
+	"hash"
+	"hash/fnv"
 	"io"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
@@ -20,8 +29,30 @@ type XMLAttribute interface {
 	SetValue(string)
 }
 
+// NodeType 表示节点的类型,参见XMLNode.Type
+type NodeType int
+
+const (
+	ElementNode   NodeType = iota + 1 // XMLElement
+	TextNode                          // XMLText,包括CDATA
+	CommentNode                       // XMLComment
+	ProcInstNode                      // XMLProcInst
+	DirectiveNode                     // XMLDirective
+	DocumentNode                      // XMLDocument
+)
+
 // XMLNode 定义了XML所有节点的基础设施，提供了基本的元素遍历、增删等操作,也提供了逆向转换能力.
+//
+// Type返回节点的类型,可以直接用在switch语句中,不必逐个调用ToElement、ToText等接口再判断是否为nil.
+//
+// PrevSibling、NextSibling跳过其他类型的节点,返回之前、之后最近的类型为t的兄弟节点,找不到时返回nil,
+// 如elem.PrevSibling(CommentNode)可以找到元素前面最近的注释.
+//
+// InsertCommentBack、InsertCommentFront、InsertCommentEndChild、InsertCommentFirstChild以及
+// InsertTextBack、InsertTextFront、InsertTextEndChild、InsertTextFirstChild与InsertElement*系列相同,
+// 分别创建一个注释、文本节点插入到对应的位置并返回它.
 type XMLNode interface {
+	Type() NodeType
 	ToElement() XMLElement
 	ToText() XMLText
 	ToComment() XMLComment
@@ -33,17 +64,29 @@ type XMLNode interface {
 	SetValue(newValue string)
 
 	Document() XMLDocument
+	Depth() int
+	SourceRange() (int, int)
+	IsAncestorOf(other XMLNode) bool
+	IsDescendantOf(other XMLNode) bool
+	Path() string
 
 	NoChildren() bool
+	CountChildren() int
+	CountDescendants() int
+	CountElements(name string) int
 	Parent() XMLNode
 	FirstChild() XMLNode
 	LastChild() XMLNode
 	Prev() XMLNode
 	Next() XMLNode
+	PrevSibling(t NodeType) XMLNode
+	NextSibling(t NodeType) XMLNode
 	FirstChildElement(name string) XMLElement
 	LastChildElement(name string) XMLElement
 	PrevElement(name string) XMLElement
 	NextElement(name string) XMLElement
+	FirstChildElementNS(space string, local string) XMLElement
+	RequireChildElement(name string) (XMLElement, error)
 
 	InsertBack(node XMLNode) XMLNode
 	InsertFront(node XMLNode) XMLNode
@@ -55,8 +98,28 @@ type XMLNode interface {
 	InsertElementEndChild(name string) XMLElement
 	InsertElementFirstChild(name string) XMLElement
 
+	InsertCommentBack(comment string) XMLComment
+	InsertCommentFront(comment string) XMLComment
+	InsertCommentEndChild(comment string) XMLComment
+	InsertCommentFirstChild(comment string) XMLComment
+
+	InsertTextBack(text string) XMLText
+	InsertTextFront(text string) XMLText
+	InsertTextEndChild(text string) XMLText
+	InsertTextFirstChild(text string) XMLText
+
+	AddChild(name string, attrs map[string]string, text string) XMLElement
+	AddChildOrdered(name string, kv ...string) XMLElement
+
+	MoveToEndChildOf(parent XMLNode) XMLNode
+	MoveBefore(sibling XMLNode) XMLNode
+	MoveAfter(sibling XMLNode) XMLNode
+	WrapIn(name string) XMLElement
+
 	DeleteChildren()
 	DeleteChild(node XMLNode)
+	TakeChildren() []XMLNode
+	Normalize()
 
 	Split() XMLNode
 
@@ -69,6 +132,7 @@ type XMLNode interface {
 	setPrev(node XMLNode)
 	setNext(node XMLNode)
 	setDocument(doc XMLDocument)
+	setSourceRange(start int, end int)
 	//impl() XMLNode
 
 	unlink(child XMLNode)
@@ -77,43 +141,113 @@ type XMLNode interface {
 // XMLElement  提供了访问XML基本节点元素的能力
 //
 // Name、SetName其实是Value和SetValue的别名，目的是为了使得接口更加符合直观理解。
+// SetNameE与SetName相同，只是新的名字不符合XML规范时不做任何修改并返回错误，参见ValidName。
 //
 // Text、SetText的作用是设置<node>与</node>之间的文字，虽然文字都是有XMLText对象来承载的，但是通常来说直接在XMLElement中访问会更加方便。
 //
-// FindAttribute和ForeachAttribute分别用于查找特定的XML节点的属性和遍历XML属性列表。
+// FindAttribute和ForeachAttribute分别用于查找特定的XML节点的属性和遍历XML属性列表，AttributeNames、Attributes按添加的顺序
+// 返回所有属性名和所有属性，返回的切片是新分配的，调用者可以随意排序、过滤，但是切片中的属性对象与元素共享，修改属性值会直接生效。
 //
 // Attribute、SetAttribute、DeleteAttribute用于读取和删除属性。
+//
+// HasAttribute、RemoveAttribute用于判断属性是否存在和删除属性，返回值都是bool，比直接判断接口是否为nil更加直观。
+//
+// LookupAttribute采用Go惯用的comma-ok形式，可以区分属性不存在和属性值为空串这两种情况。
+//
+// AttributeNS按照名字空间URI和本地名来读取属性，不关心属性实际使用的是哪个前缀。
+//
+// DeclareNamespace、UndeclareNamespace用于添加和删除本元素上的名字空间声明(即xmlns、xmlns:prefix属性)，
+// LookupNamespaceURI从本元素开始逐级向上查找前缀绑定的名字空间URI。
+//
+// RequireAttribute在属性不存在时返回错误，适用于属性缺失就是错误的场景。
+//
+// SetAttributes、SetAttributesMap用于一次设置多个属性，SetAttributes的参数是属性名、属性值交替排列的，个数为奇数时会panic。
+//
+// SetAttributeE与SetAttribute相同，只是属性名不符合XML规范时不做任何修改并返回错误，参见ValidName。
+//
+// CollapsedText将元素直接包含的所有文本(包括CDATA，不含子元素中的文本)拼接起来，去掉首尾空白并将连续的空白合并成一个空格，
+// 适合读取手工编写、带有缩进的XML。
+//
+// TextAsInt、TextAsFloat、TextAsBool将CollapsedText()的结果解析成对应类型的值，如<count>42</count>，
+// 内容不合法(包括没有内容)时返回错误。布尔值的格式与strconv.ParseBool相同，可以是true、false、1、0等。
+//
+// TextSegments按顺序返回每个直接子文本节点(包括CDATA)的内容，跳过子元素等其他节点，
+// 与Text()只返回第一段、CollapsedText()拼接所有文本不同，适合逐段处理混合内容中的文本。
+//
+// SetCDATAText与SetText类似，只是承载文字的XMLText对象会被标记为CDATA，常用于嵌入脚本之类的内容。
+//
+// IsEmpty、HasElementChildren是语义上的判断，与NoChildren不同，IsEmpty只关心是否有子元素以及是否有非空白的文本，
+// 注释、处理指令等节点不影响判断结果，只含空白的CDATA也视为空。
+//
+// SameTag判断两个元素的“标签”是否相同：元素名相同，并且属性的名字和值组成的集合相同，不考虑属性的顺序，也不比较子节点，
+// 常用于对重复的记录进行分组、去重。需要连同子节点一起比较时请使用DeepEqual。
 type XMLElement interface {
 	XMLNode
 
 	Name() string
 	SetName(name string)
+	SetNameE(name string) error
 
 	FindAttribute(name string) XMLAttribute
 	ForeachAttribute(callback func(attribute XMLAttribute) int) int
 
 	AttributeCount() int
+	AttributeNames() []string
+	Attributes() []XMLAttribute
 	Attribute(name string, def string) string
+	LookupAttribute(name string) (string, bool)
+	AttributeNS(space string, local string, def string) string
+	RequireAttribute(name string) (string, error)
 	SetAttribute(name string, value string) XMLAttribute
+	SetAttributeE(name string, value string) (XMLAttribute, error)
+	SetAttributes(kv ...string)
+	SetAttributesMap(attrs map[string]string)
 	DeleteAttribute(name string) XMLAttribute
+	DeclareNamespace(prefix string, uri string) error
+	UndeclareNamespace(prefix string) bool
+	LookupNamespaceURI(prefix string) string
+	HasAttribute(name string) bool
+	RemoveAttribute(name string) bool
 	ClearAttributes()
 
 	Text() string
+	CollapsedText() string
+	TextSegments() []string
+	TextAsInt() (int, error)
+	TextAsFloat() (float64, error)
+	TextAsBool() (bool, error)
 	SetText(text string)
+	SetCDATAText(text string)
+
+	IsEmpty() bool
+	HasElementChildren() bool
+	SameTag(other XMLElement) bool
+	ElementIndex() int
+
+	Unwrap()
 }
 
 // XMLText 提供了对XML元素间文本的封装
+//
+// SetRaw(true)之后,文本在输出时不再转义,而是原样逐字节输出,用于输出已经转义过的历史数据,避免重复转义.
+// 注意:这时由调用者保证文本是合法的XML内容,否则输出的文档将无法被解析.CDATA的文本本来就是原样输出的,不受Raw影响.
 type XMLText interface {
 	XMLNode
 	SetCDATA(isCData bool)
 	CDATA() bool
+	SetRaw(raw bool)
+	Raw() bool
 }
 
 // XMLComment 提供了对注释的封装
+//
+// XML规定注释中不能出现--,也不能以-结尾,SetComment不做检查,不合法的注释输出之后文档将无法被解析.
+// SetCommentE在内容不合法时不做任何修改并返回错误,参见ValidComment
 type XMLComment interface {
 	XMLNode
 	Comment() string
 	SetComment(string)
+	SetCommentE(string) error
 }
 
 // XMLProcInst 常用于表达XML处理指令,类似:<?xml version="1.0" encoding="UTF-8"?>
@@ -129,8 +263,22 @@ type XMLDirective interface {
 }
 
 // XMLDocument 用于表达一个XML文档,这是整个XML文档的根
+//
+// Version、Encoding、Standalone用于读取XML声明(<?xml version="1.0" encoding="UTF-8" standalone="yes"?>)中的对应字段，
+// 文档没有XML声明或者声明中没有该字段时返回空串。
+//
+// RootElement返回文档的根元素，会跳过根元素之前的XML声明、注释、DOCTYPE等节点，文档中还没有根元素时返回nil，
+// 与FirstChildElement("")的结果相同。
+//
+// 关于并发：tinydom的所有读取操作都不会修改DOM树，所以只要没有任何goroutine修改文档，多个goroutine并发读取同一个文档是安全的。
+// 一旦有goroutine需要修改文档，就需要调用者自行加锁，或者通过Snapshot得到一份完全独立的深拷贝交给其他goroutine使用。
 type XMLDocument interface {
 	XMLNode
+	Version() string
+	Encoding() string
+	Standalone() string
+	RootElement() XMLElement
+	Snapshot() XMLDocument
 }
 
 // XMLVisitor XML文档访问器,常用于遍历文档或者格式化输出XML文档
@@ -201,6 +349,11 @@ type xmlNodeImpl struct {
 
 	prev XMLNode
 	next XMLNode
+
+	// 节点在原始输入流中的字节范围[srcStart,srcEnd),只在解析时记录
+	srcStart int
+	srcEnd   int
+	srcValid bool
 }
 
 func (n *xmlNodeImpl) setParent(node XMLNode) {
@@ -219,6 +372,12 @@ func (n *xmlNodeImpl) setDocument(doc XMLDocument) {
 	n.document = doc
 }
 
+func (n *xmlNodeImpl) setSourceRange(start int, end int) {
+	n.srcStart = start
+	n.srcEnd = end
+	n.srcValid = true
+}
+
 func (n *xmlNodeImpl) ToElement() XMLElement {
 	return nil
 }
@@ -259,10 +418,156 @@ func (n *xmlNodeImpl) Parent() XMLNode {
 	return n.parent
 }
 
+// SourceRange 返回节点在被解析的原始输入流中所占的字节范围[start,end),元素的范围包括其开始标签到结束标签的全部内容.
+// 该范围只在解析时记录,后续修改文档时不会更新;不是通过解析得到的节点返回-1,-1.
+// 输入流的编码声明不是UTF-8、由LoadOptions.CharsetReader转换过时,解析器看到的是转换之后的内容,
+// 无法对应到原始输入中的字节,这时所有节点(包括XML声明和文档本身)都返回-1,-1
+func (n *xmlNodeImpl) SourceRange() (int, int) {
+	if !n.srcValid {
+		return -1, -1
+	}
+
+	return n.srcStart, n.srcEnd
+}
+
+// Depth 返回节点的深度,即该节点到document之间(不含document)的祖先节点的个数,根元素的深度为0.
+// 没有父节点的游离节点,深度也为0
+func (n *xmlNodeImpl) Depth() int {
+	depth := 0
+	for node := n.parent; (nil != node) && (nil == node.ToDocument()); node = node.Parent() {
+		depth++
+	}
+
+	return depth
+}
+
+// IsAncestorOf 判断本节点是否是other的祖先节点(父节点、父节点的父节点...),节点不是它自己的祖先,判断是否是同一个节点直接用==即可
+func (n *xmlNodeImpl) IsAncestorOf(other XMLNode) bool {
+	if nil == other {
+		return false
+	}
+
+	for node := other.Parent(); nil != node; node = node.Parent() {
+		if node == n.implobj {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsDescendantOf 判断本节点是否是other的子孙节点,节点不是它自己的子孙
+func (n *xmlNodeImpl) IsDescendantOf(other XMLNode) bool {
+	return (nil != other) && other.IsAncestorOf(n.implobj)
+}
+
+// Path 返回一个类似XPath的字符串来描述节点在文档中的位置,如/root/items/item[3]/name,常用于日志和校验错误信息.
+// 元素只有在存在同名兄弟元素时才带上从1开始的序号,其他节点使用text()[1]、comment()[1]、processing-instruction()[1]、
+// directive()[1]这样的步骤并总是带上序号.文档本身的路径是/,不属于任何文档的游离子树的路径不以/开头
+func (n *xmlNodeImpl) Path() string {
+	if nil != n.implobj.ToDocument() {
+		return "/"
+	}
+
+	var steps []string
+	var node XMLNode = n.implobj
+	for ; (nil != node) && (nil == node.ToDocument()); node = node.Parent() {
+		steps = append(steps, pathStep(node))
+	}
+
+	// 反转成从上到下的顺序
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+
+	path := strings.Join(steps, "/")
+	if nil != node {
+		path = "/" + path
+	}
+
+	return path
+}
+
+// pathStep 返回node在Path中对应的一步
+func pathStep(node XMLNode) string {
+	step := pathKind(node)
+	elem := node.ToElement()
+	if nil == elem {
+		index := 1
+		for sibling := node.Prev(); nil != sibling; sibling = sibling.Prev() {
+			if pathKind(sibling) == step {
+				index++
+			}
+		}
+		return step + "[" + strconv.Itoa(index) + "]"
+	}
+
+	index := elem.ElementIndex()
+	if 1 == index {
+		// 后面也没有同名的兄弟元素时不带序号
+		if nil == elem.NextElement(step) {
+			return step
+		}
+	}
+
+	return step + "[" + strconv.Itoa(index) + "]"
+}
+
+// pathKind 返回node在Path中不带序号的步骤,元素是元素名,其他节点是text()这样的节点类型
+func pathKind(node XMLNode) string {
+	switch node.Type() {
+	case ElementNode:
+		return node.Value()
+	case TextNode:
+		return "text()"
+	case CommentNode:
+		return "comment()"
+	case ProcInstNode:
+		return "processing-instruction()"
+	case DocumentNode:
+		return ""
+	}
+
+	return "directive()"
+}
+
 func (n *xmlNodeImpl) NoChildren() bool {
 	return nil == n.firstChild
 }
 
+// CountChildren 返回直接子节点的个数,包括所有类型的节点
+func (n *xmlNodeImpl) CountChildren() int {
+	count := 0
+	for item := n.firstChild; nil != item; item = item.Next() {
+		count++
+	}
+
+	return count
+}
+
+// CountDescendants 返回所有子孙节点的个数,包括所有类型的节点,但不包括节点自身
+func (n *xmlNodeImpl) CountDescendants() int {
+	count := 0
+	for item := n.firstChild; nil != item; item = item.Next() {
+		count += 1 + item.CountDescendants()
+	}
+
+	return count
+}
+
+// CountElements 返回子孙节点中名字为name的元素的个数,name为空串时统计所有的元素,不包括节点自身
+func (n *xmlNodeImpl) CountElements(name string) int {
+	count := 0
+	for item := n.firstChild; nil != item; item = item.Next() {
+		if elem := item.ToElement(); (nil != elem) && nameMatches(elem.Name(), name) {
+			count++
+		}
+		count += item.CountElements(name)
+	}
+
+	return count
+}
+
 func (n *xmlNodeImpl) FirstChild() XMLNode {
 	return n.firstChild
 }
@@ -279,6 +584,26 @@ func (n *xmlNodeImpl) Next() XMLNode {
 	return n.next
 }
 
+func (n *xmlNodeImpl) PrevSibling(t NodeType) XMLNode {
+	for node := n.prev; nil != node; node = node.Prev() {
+		if t == node.Type() {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func (n *xmlNodeImpl) NextSibling(t NodeType) XMLNode {
+	for node := n.next; nil != node; node = node.Next() {
+		if t == node.Type() {
+			return node
+		}
+	}
+
+	return nil
+}
+
 func (n *xmlNodeImpl) FirstChildElement(name string) XMLElement {
 	for item := n.firstChild; nil != item; item = item.Next() {
 		elem := item.ToElement()
@@ -286,7 +611,7 @@ func (n *xmlNodeImpl) FirstChildElement(name string) XMLElement {
 			continue
 		}
 
-		if ("" == name) || (elem.Name() == name) {
+		if nameMatches(elem.Name(), name) {
 			return elem
 		}
 	}
@@ -302,7 +627,7 @@ func (n *xmlNodeImpl) LastChildElement(name string) XMLElement {
 			continue
 		}
 
-		if ("" == name) || (elem.Name() == name) {
+		if nameMatches(elem.Name(), name) {
 			return elem
 		}
 	}
@@ -317,7 +642,7 @@ func (n *xmlNodeImpl) PrevElement(name string) XMLElement {
 			continue
 		}
 
-		if ("" == name) || (elem.Name() == name) {
+		if nameMatches(elem.Name(), name) {
 			return elem
 		}
 	}
@@ -333,7 +658,28 @@ func (n *xmlNodeImpl) NextElement(name string) XMLElement {
 			continue
 		}
 
-		if ("" == name) || (elem.Name() == name) {
+		if nameMatches(elem.Name(), name) {
+			return elem
+		}
+	}
+
+	return nil
+}
+
+// FirstChildElementNS 按照名字空间URI和本地名查找第一个子元素,space为空串时表示匹配任意名字空间
+func (n *xmlNodeImpl) FirstChildElementNS(space string, local string) XMLElement {
+	for item := n.firstChild; nil != item; item = item.Next() {
+		elem := item.ToElement()
+		if nil == elem {
+			continue
+		}
+
+		prefix, name := splitQualifiedName(elem.Name())
+		if ("" != local) && (name != local) {
+			continue
+		}
+
+		if ("" == space) || (lookupNamespaceURI(elem, prefix) == space) {
 			return elem
 		}
 	}
@@ -341,6 +687,24 @@ func (n *xmlNodeImpl) NextElement(name string) XMLElement {
 	return nil
 }
 
+// RequireChildElement 与FirstChildElement类似,但找不到时返回一个描述性的错误而不是nil,常用于解析配置文件这种缺少元素就是错误的场景
+func (n *xmlNodeImpl) RequireChildElement(name string) (XMLElement, error) {
+	if elem := n.FirstChildElement(name); nil != elem {
+		return elem, nil
+	}
+
+	owner := "document"
+	if nil == n.implobj.ToDocument() {
+		owner = n.value
+	}
+
+	if "" == name {
+		return nil, errors.New("No child element under " + owner)
+	}
+
+	return nil, errors.New("No child element named " + name + " under " + owner)
+}
+
 func (n *xmlNodeImpl) Split() XMLNode {
 
 	if nil != n.parent {
@@ -370,8 +734,10 @@ func (n *xmlNodeImpl) unlink(child XMLNode) {
 	}
 
 	child.setParent(nil)
+	child.setPrev(nil)
+	child.setNext(nil)
 
-	child.setDocument(nil)
+	setSubtreeDocument(child, nil)
 }
 
 func (n *xmlNodeImpl) InsertEndChild(addThis XMLNode) XMLNode {
@@ -391,7 +757,7 @@ func (n *xmlNodeImpl) InsertEndChild(addThis XMLNode) XMLNode {
 	}
 
 	addThis.setParent(n.implobj)
-	addThis.setDocument(n.document)
+	setSubtreeDocument(addThis, n.document)
 	return addThis
 }
 
@@ -412,7 +778,7 @@ func (n *xmlNodeImpl) InsertFirstChild(addThis XMLNode) XMLNode {
 	}
 
 	addThis.setParent(n.implobj)
-	addThis.setDocument(n.document)
+	setSubtreeDocument(addThis, n.document)
 	return addThis
 }
 
@@ -433,7 +799,7 @@ func (n *xmlNodeImpl) insertAfterChild(afterThis XMLNode, addThis XMLNode) XMLNo
 	afterThis.Next().setPrev(addThis)
 	afterThis.setNext(addThis)
 	addThis.setParent(n.implobj)
-	addThis.setDocument(n.document)
+	setSubtreeDocument(addThis, n.document)
 
 	return addThis
 }
@@ -455,7 +821,7 @@ func (n *xmlNodeImpl) insertBeforeChild(beforeThis XMLNode, addThis XMLNode) XML
 	beforeThis.Prev().setNext(addThis)
 	beforeThis.setPrev(addThis)
 	addThis.setParent(n.implobj)
-	addThis.setDocument(n.document)
+	setSubtreeDocument(addThis, n.document)
 
 	return addThis
 }
@@ -492,6 +858,188 @@ func (n *xmlNodeImpl) InsertElementFirstChild(name string) XMLElement {
 	return n.InsertFirstChild(NewElement(name)).ToElement()
 }
 
+func (n *xmlNodeImpl) InsertCommentFront(comment string) XMLComment {
+	return n.InsertFront(NewComment(comment)).ToComment()
+}
+
+func (n *xmlNodeImpl) InsertCommentBack(comment string) XMLComment {
+	return n.InsertBack(NewComment(comment)).ToComment()
+}
+
+func (n *xmlNodeImpl) InsertCommentEndChild(comment string) XMLComment {
+	return n.InsertEndChild(NewComment(comment)).ToComment()
+}
+
+func (n *xmlNodeImpl) InsertCommentFirstChild(comment string) XMLComment {
+	return n.InsertFirstChild(NewComment(comment)).ToComment()
+}
+
+func (n *xmlNodeImpl) InsertTextFront(text string) XMLText {
+	return n.InsertFront(NewText(text)).ToText()
+}
+
+func (n *xmlNodeImpl) InsertTextBack(text string) XMLText {
+	return n.InsertBack(NewText(text)).ToText()
+}
+
+func (n *xmlNodeImpl) InsertTextEndChild(text string) XMLText {
+	return n.InsertEndChild(NewText(text)).ToText()
+}
+
+func (n *xmlNodeImpl) InsertTextFirstChild(text string) XMLText {
+	return n.InsertFirstChild(NewText(text)).ToText()
+}
+
+// InsertSiblingAfter 与ref.InsertBack(newNode)相同,将newNode插入到ref之后,只是无法插入时返回错误而不是nil:
+// ref没有父节点、newNode是文档、newNode就是ref或者是ref的祖先节点(插入之后会形成环)
+func InsertSiblingAfter(ref XMLNode, newNode XMLNode) (XMLNode, error) {
+	if err := checkSiblingInsert(ref, newNode); nil != err {
+		return nil, err
+	}
+
+	return ref.InsertBack(newNode), nil
+}
+
+// InsertSiblingBefore 与ref.InsertFront(newNode)相同,将newNode插入到ref之前,无法插入时返回错误,参见InsertSiblingAfter
+func InsertSiblingBefore(ref XMLNode, newNode XMLNode) (XMLNode, error) {
+	if err := checkSiblingInsert(ref, newNode); nil != err {
+		return nil, err
+	}
+
+	return ref.InsertFront(newNode), nil
+}
+
+// checkSiblingInsert 检查能否将newNode作为兄弟节点插入到ref的前后
+func checkSiblingInsert(ref XMLNode, newNode XMLNode) error {
+	switch {
+	case (nil == ref) || (nil == newNode):
+		return errors.New("Reference node and new node must not be nil")
+	case nil == ref.Parent():
+		return errors.New("Reference node has no parent:" + pathKind(ref))
+	case nil != newNode.ToDocument():
+		return errors.New("Document can not be inserted as a sibling")
+	case (ref == newNode) || newNode.IsAncestorOf(ref):
+		return errors.New("Node can not be inserted beside itself or its descendant:" + pathKind(newNode))
+	}
+
+	return nil
+}
+
+// Normalize 与W3C DOM的normalize()语义相同,递归地将相邻的Text节点合并成一个,并删除内容为空的Text节点.
+// CDATA、Raw文本和普通Text的输出方式不同,所以只有这两个标记都相同的相邻Text才会被合并
+func (n *xmlNodeImpl) Normalize() {
+	for child := n.firstChild; nil != child; {
+		next := child.Next()
+
+		text := child.ToText()
+		if nil == text {
+			child.Normalize()
+			child = next
+			continue
+		}
+
+		if "" == text.Value() {
+			n.unlink(child)
+			child = next
+			continue
+		}
+
+		// 空的Text节点在这里直接删除,使得它两边可以合并的节点也能合并
+		for (nil != next) && (nil != next.ToText()) {
+			if "" == next.Value() {
+				n.unlink(next)
+			} else if (next.ToText().CDATA() == text.CDATA()) && (next.ToText().Raw() == text.Raw()) {
+				text.SetValue(text.Value() + next.Value())
+				n.unlink(next)
+			} else {
+				break
+			}
+			next = child.Next()
+		}
+
+		child = next
+	}
+}
+
+// MoveToEndChildOf 将本节点从原来的位置拆除,并添加为parent的最后一个子节点,可以跨文档移动
+func (n *xmlNodeImpl) MoveToEndChildOf(parent XMLNode) XMLNode {
+	if nil == parent {
+		return nil
+	}
+
+	return parent.InsertEndChild(n.implobj)
+}
+
+// MoveBefore 将本节点从原来的位置拆除,并插入到sibling的前面,sibling没有父节点时返回nil
+func (n *xmlNodeImpl) MoveBefore(sibling XMLNode) XMLNode {
+	if (nil == sibling) || (nil == sibling.Parent()) {
+		return nil
+	}
+
+	if sibling == n.implobj {
+		return n.implobj
+	}
+
+	return sibling.InsertFront(n.implobj)
+}
+
+// MoveAfter 将本节点从原来的位置拆除,并插入到sibling的后面,sibling没有父节点时返回nil
+func (n *xmlNodeImpl) MoveAfter(sibling XMLNode) XMLNode {
+	if (nil == sibling) || (nil == sibling.Parent()) {
+		return nil
+	}
+
+	if sibling == n.implobj {
+		return n.implobj
+	}
+
+	return sibling.InsertBack(n.implobj)
+}
+
+// WrapIn 新建一个名为name的元素放到本节点原来的位置上,再将本节点移动为它唯一的子节点,返回新建的元素.
+// 文档节点不能被包裹,此时返回nil
+func (n *xmlNodeImpl) WrapIn(name string) XMLElement {
+	if nil != n.implobj.ToDocument() {
+		return nil
+	}
+
+	wrapper := NewElement(name)
+	if nil != n.parent {
+		n.parent.insertBeforeChild(n.implobj, wrapper)
+	} else {
+		wrapper.setDocument(n.document)
+	}
+
+	wrapper.InsertEndChild(n.implobj)
+	return wrapper
+}
+
+// AddChild 新建一个名为name的元素,设置其属性和文本(text为空串时不设置)之后添加为本节点的最后一个子节点.
+// 由于map是无序的,属性按照属性名排序之后依次添加,以保证输出结果是确定的
+func (n *xmlNodeImpl) AddChild(name string, attrs map[string]string, text string) XMLElement {
+	elem := NewElement(name)
+	elem.SetAttributesMap(attrs)
+
+	if "" != text {
+		elem.SetText(text)
+	}
+
+	return n.InsertEndChild(elem).ToElement()
+}
+
+// AddChildOrdered 新建一个名为name的元素,按照kv中属性名、属性值交替排列的顺序设置属性之后添加为本节点的最后一个子节点.
+// kv的个数必须是偶数,否则会panic
+func (n *xmlNodeImpl) AddChildOrdered(name string, kv ...string) XMLElement {
+	if 0 != len(kv)%2 {
+		panic("tinydom: AddChildOrdered expects an even number of key/value arguments")
+	}
+
+	elem := NewElement(name)
+	elem.SetAttributes(kv...)
+
+	return n.InsertEndChild(elem).ToElement()
+}
+
 func (n *xmlNodeImpl) DeleteChildren() {
 	for nil != n.firstChild {
 		n.DeleteChild(n.firstChild)
@@ -505,10 +1053,68 @@ func (n *xmlNodeImpl) DeleteChild(node XMLNode) {
 	n.unlink(node)
 }
 
+// TakeChildren 按顺序拆除所有的子节点并返回,与DeleteChildren不同的是返回的节点可以直接插入到其他地方
+func (n *xmlNodeImpl) TakeChildren() []XMLNode {
+	children := make([]XMLNode, 0, n.CountChildren())
+	for nil != n.firstChild {
+		child := n.firstChild
+		n.unlink(child)
+		children = append(children, child)
+	}
+
+	return children
+}
+
 //func (n *xmlNodeImpl) Accept(visitor XMLVisitor) bool {
 //	return n.implobj.Accept(visitor)
 //}
 
+// setSubtreeDocument 将node及其所有子孙节点所属的文档都设置为doc
+func setSubtreeDocument(node XMLNode, doc XMLDocument) {
+	node.setDocument(doc)
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		setSubtreeDocument(child, doc)
+	}
+}
+
+// shallowClone 复制node自身(不含子节点),返回的节点是游离的
+func shallowClone(node XMLNode) XMLNode {
+	switch {
+	case nil != node.ToElement():
+		elem := NewElement(node.Value())
+		node.ToElement().ForeachAttribute(func(attr XMLAttribute) int {
+			setAttributeExact(elem, attr.Name(), attr.Value())
+			return 0
+		})
+		if impl, ok := node.(*xmlElementImpl); ok {
+			elem.(*xmlElementImpl).closeForm = impl.closeForm
+		}
+		return elem
+	case nil != node.ToText():
+		text := NewText(node.Value())
+		text.SetCDATA(node.ToText().CDATA())
+		text.SetRaw(node.ToText().Raw())
+		return text
+	case nil != node.ToComment():
+		return NewComment(node.Value())
+	case nil != node.ToProcInst():
+		return NewProcInst(node.Value(), node.ToProcInst().Instruction())
+	case nil != node.ToDirective():
+		return NewDirective(node.Value())
+	}
+
+	return NewDocument()
+}
+
+// cloneChildren 将from的所有子孙节点深度复制一份,并依次添加为to的子节点
+func cloneChildren(from XMLNode, to XMLNode) {
+	for child := from.FirstChild(); nil != child; child = child.Next() {
+		// 先挂到父节点上再复制子节点,避免每层插入时都重新设置整棵子树的document
+		node := to.InsertEndChild(shallowClone(child))
+		cloneChildren(child, node)
+	}
+}
+
 // ------------------------------------------------------------------
 
 type xmlElementImpl struct {
@@ -517,6 +1123,21 @@ type xmlElementImpl struct {
 	// rootAttribute XMLAttribute
 	attrlist *list.List
 	attrsmap map[string]*list.Element
+
+	closeForm closeForm // 加载时元素原来的结束形式,参见PrintOptions.PreserveSelfClose
+}
+
+// closeForm 元素在原始文档中的结束形式
+type closeForm int8
+
+const (
+	closeFormUnknown   closeForm = iota // 不是加载得到的元素,或者无法判断
+	closeFormSelfClose                  // <a/>
+	closeFormEndTag                     // <a></a>
+)
+
+func (e *xmlElementImpl) Type() NodeType {
+	return ElementNode
 }
 
 func (e *xmlElementImpl) ToElement() XMLElement {
@@ -544,53 +1165,260 @@ func (e *xmlElementImpl) SetName(name string) {
 	e.SetValue(name)
 }
 
+// SetNameE 与SetName相同,只是name不是合法的XML名字时返回错误
+func (e *xmlElementImpl) SetNameE(name string) error {
+	if !ValidName(name) {
+		return errors.New("Invalid element name:" + name)
+	}
+
+	e.SetName(name)
+	return nil
+}
+
 func (e *xmlElementImpl) FindAttribute(name string) XMLAttribute {
-	elem, ok := e.attrsmap[name]
-	if !ok {
+	elem := e.findAttribute(name)
+	if nil == elem {
 		return nil
 	}
 
 	return elem.Value.(*xmlAttributeImpl)
 }
 
-func (e *xmlElementImpl) AttributeCount() int {
-	return len(e.attrsmap)
-}
-
-func (e *xmlElementImpl) Attribute(name string, def string) string {
-	attr, ok := e.attrsmap[name]
-	if !ok {
-		return def
+// findAttribute 按照nameMatches的规则查找属性.一个元素上可能同时有id和a:id,所以先按完整的名字查找,
+// 找不到时再按照属性的顺序查找第一个本地名匹配的带前缀属性
+func (e *xmlElementImpl) findAttribute(name string) *list.Element {
+	if elem, ok := e.attrsmap[name]; ok {
+		return elem
 	}
 
-	return attr.Value.(*xmlAttributeImpl).Value()
-}
+	if "" == name {
+		return nil
+	}
 
-func (e *xmlElementImpl) SetAttribute(name string, value string) XMLAttribute {
-	elem, ok := e.attrsmap[name]
-	if ok {
-		elem.Value.(*xmlAttributeImpl).SetValue(value)
-		return elem.Value.(*xmlAttributeImpl)
+	for elem := e.attrlist.Front(); nil != elem; elem = elem.Next() {
+		if nameMatches(elem.Value.(*xmlAttributeImpl).name, name) {
+			return elem
+		}
 	}
 
-	attr := newAttribute(name, value)
-	e.attrsmap[name] = e.attrlist.PushBack(attr)
-	return attr
+	return nil
 }
 
-func (e *xmlElementImpl) DeleteAttribute(name string) XMLAttribute {
-	elem, ok := e.attrsmap[name]
-	if !ok {
+// attributeExact 按照完整的名字查找属性,不做本地名的匹配,用于比较、复制等需要区分id和a:id的内部操作
+func attributeExact(elem XMLElement, name string) XMLAttribute {
+	if impl, ok := elem.(*xmlElementImpl); ok {
+		if item, ok := impl.attrsmap[name]; ok {
+			return item.Value.(*xmlAttributeImpl)
+		}
+		return nil
+	}
+
+	for _, attr := range elem.Attributes() {
+		if attr.Name() == name {
+			return attr
+		}
+	}
+
+	return nil
+}
+
+// setAttributeExact 按照完整的名字设置属性,不存在时添加到最后,不做本地名的匹配,
+// 用于加载、复制这些需要原样保留id和a:id两个属性的内部操作
+func setAttributeExact(elem XMLElement, name string, value string) XMLAttribute {
+	impl, ok := elem.(*xmlElementImpl)
+	if !ok {
+		return elem.SetAttribute(name, value)
+	}
+
+	if item, ok := impl.attrsmap[name]; ok {
+		item.Value.(*xmlAttributeImpl).SetValue(value)
+		return item.Value.(*xmlAttributeImpl)
+	}
+
+	attr := newAttribute(name, value)
+	impl.attrsmap[name] = impl.attrlist.PushBack(attr)
+	return attr
+}
+
+func (e *xmlElementImpl) AttributeCount() int {
+	return len(e.attrsmap)
+}
+
+func (e *xmlElementImpl) AttributeNames() []string {
+	names := make([]string, 0, e.attrlist.Len())
+	for elem := e.attrlist.Front(); nil != elem; elem = elem.Next() {
+		names = append(names, elem.Value.(*xmlAttributeImpl).name)
+	}
+
+	return names
+}
+
+func (e *xmlElementImpl) Attributes() []XMLAttribute {
+	attrs := make([]XMLAttribute, 0, e.attrlist.Len())
+	for elem := e.attrlist.Front(); nil != elem; elem = elem.Next() {
+		attrs = append(attrs, elem.Value.(*xmlAttributeImpl))
+	}
+
+	return attrs
+}
+
+func (e *xmlElementImpl) Attribute(name string, def string) string {
+	attr := e.findAttribute(name)
+	if nil == attr {
+		return def
+	}
+
+	return attr.Value.(*xmlAttributeImpl).Value()
+}
+
+func (e *xmlElementImpl) AttributeNS(space string, local string, def string) string {
+	for elem := e.attrlist.Front(); nil != elem; elem = elem.Next() {
+		attr := elem.Value.(*xmlAttributeImpl)
+		prefix, name := splitQualifiedName(attr.name)
+		if name != local {
+			continue
+		}
+
+		// 没有前缀的属性不属于任何名字空间,而不是缺省名字空间
+		uri := ""
+		if "xmlns" == attr.name {
+			uri = xmlnsNamespaceURI
+		} else if "" != prefix {
+			uri = lookupNamespaceURI(e, prefix)
+		}
+
+		if ("" == space) || (uri == space) {
+			return attr.value
+		}
+	}
+
+	return def
+}
+
+// SetAttribute 修改按照nameMatches的规则找到的属性,如SetAttribute("id", v)会修改已有的a:id属性,找不到时添加一个新的属性
+func (e *xmlElementImpl) SetAttribute(name string, value string) XMLAttribute {
+	if elem := e.findAttribute(name); nil != elem {
+		elem.Value.(*xmlAttributeImpl).SetValue(value)
+		return elem.Value.(*xmlAttributeImpl)
+	}
+
+	return setAttributeExact(e, name, value)
+}
+
+// SetAttributeE 与SetAttribute相同,只是name不是合法的XML名字时返回错误
+func (e *xmlElementImpl) SetAttributeE(name string, value string) (XMLAttribute, error) {
+	if !ValidName(name) {
+		return nil, errors.New("Invalid attribute name:" + name)
+	}
+
+	return e.SetAttribute(name, value), nil
+}
+
+// SetAttributes 按照kv中属性名、属性值交替排列的顺序依次设置属性,kv的个数必须是偶数,否则会panic
+func (e *xmlElementImpl) SetAttributes(kv ...string) {
+	if 0 != len(kv)%2 {
+		panic("tinydom: SetAttributes expects an even number of key/value arguments")
+	}
+
+	for i := 0; i < len(kv); i += 2 {
+		e.SetAttribute(kv[i], kv[i+1])
+	}
+}
+
+// SetAttributesMap 设置attrs中的所有属性.由于map是无序的,新增的属性按照属性名排序之后依次添加,以保证输出结果是确定的
+func (e *xmlElementImpl) SetAttributesMap(attrs map[string]string) {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e.SetAttribute(key, attrs[key])
+	}
+}
+
+func (e *xmlElementImpl) DeleteAttribute(name string) XMLAttribute {
+	return e.removeAttribute(e.findAttribute(name))
+}
+
+// removeAttribute 删除属性列表中的elem,elem为nil时什么也不做
+func (e *xmlElementImpl) removeAttribute(elem *list.Element) XMLAttribute {
+	if nil == elem {
 		return nil
 	}
 
 	attr := elem.Value.(*xmlAttributeImpl)
 
 	e.attrlist.Remove(elem)
-	delete(e.attrsmap, name)
+	delete(e.attrsmap, attr.name)
 	return attr
 }
 
+func (e *xmlElementImpl) RequireAttribute(name string) (string, error) {
+	attr := e.findAttribute(name)
+	if nil == attr {
+		return "", errors.New("No attribute named " + name + " on element " + e.Name())
+	}
+
+	return attr.Value.(*xmlAttributeImpl).Value(), nil
+}
+
+// namespaceAttributeName 返回声明前缀prefix时使用的属性名,prefix为空串时是缺省名字空间
+func namespaceAttributeName(prefix string) string {
+	if "" == prefix {
+		return "xmlns"
+	}
+
+	return "xmlns:" + prefix
+}
+
+// DeclareNamespace 在本元素上声明前缀prefix绑定到uri,prefix为空串时声明的是缺省名字空间,已经声明过时修改为新的uri.
+// xml、xmlns两个前缀是XML规范保留的,不能声明;除缺省名字空间之外,uri也不能为空串
+func (e *xmlElementImpl) DeclareNamespace(prefix string, uri string) error {
+	if ("xml" == prefix) || ("xmlns" == prefix) {
+		return errors.New("Reserved namespace prefix:" + prefix)
+	}
+
+	if ("" != prefix) && (!ValidName(prefix) || strings.ContainsRune(prefix, ':')) {
+		return errors.New("Invalid namespace prefix:" + prefix)
+	}
+
+	if ("" != prefix) && ("" == uri) {
+		return errors.New("Namespace URI is empty for prefix:" + prefix)
+	}
+
+	setAttributeExact(e, namespaceAttributeName(prefix), uri)
+	return nil
+}
+
+// UndeclareNamespace 删除本元素上对前缀prefix的声明,返回是否存在这样的声明
+func (e *xmlElementImpl) UndeclareNamespace(prefix string) bool {
+	return nil != e.removeAttribute(e.attrsmap[namespaceAttributeName(prefix)])
+}
+
+// LookupNamespaceURI 从本元素开始逐级向上查找前缀prefix绑定的名字空间URI,prefix为空串时查找缺省名字空间,找不到时返回空串
+func (e *xmlElementImpl) LookupNamespaceURI(prefix string) string {
+	return lookupNamespaceURI(e, prefix)
+}
+
+func (e *xmlElementImpl) LookupAttribute(name string) (string, bool) {
+	attr := e.findAttribute(name)
+	if nil == attr {
+		return "", false
+	}
+
+	return attr.Value.(*xmlAttributeImpl).Value(), true
+}
+
+func (e *xmlElementImpl) HasAttribute(name string) bool {
+	return nil != e.findAttribute(name)
+}
+
+func (e *xmlElementImpl) RemoveAttribute(name string) bool {
+	return nil != e.DeleteAttribute(name)
+}
+
 func (e *xmlElementImpl) Text() string {
 	if text := e.FirstChild(); (nil != text) && (nil != text.ToText()) {
 		return text.Value()
@@ -599,6 +1427,51 @@ func (e *xmlElementImpl) Text() string {
 	return ""
 }
 
+func (e *xmlElementImpl) CollapsedText() string {
+	return collapseWhitespace(directText(e))
+}
+
+func (e *xmlElementImpl) TextSegments() []string {
+	segments := make([]string, 0)
+	for child := e.FirstChild(); nil != child; child = child.Next() {
+		if nil != child.ToText() {
+			segments = append(segments, child.Value())
+		}
+	}
+
+	return segments
+}
+
+func (e *xmlElementImpl) TextAsInt() (int, error) {
+	text := e.CollapsedText()
+	value, err := strconv.Atoi(text)
+	if nil != err {
+		return 0, errors.New("Text of element <" + e.Name() + "> is not an integer:" + text)
+	}
+
+	return value, nil
+}
+
+func (e *xmlElementImpl) TextAsFloat() (float64, error) {
+	text := e.CollapsedText()
+	value, err := strconv.ParseFloat(text, 64)
+	if nil != err {
+		return 0, errors.New("Text of element <" + e.Name() + "> is not a number:" + text)
+	}
+
+	return value, nil
+}
+
+func (e *xmlElementImpl) TextAsBool() (bool, error) {
+	text := e.CollapsedText()
+	value, err := strconv.ParseBool(text)
+	if nil != err {
+		return false, errors.New("Text of element <" + e.Name() + "> is not a boolean:" + text)
+	}
+
+	return value, nil
+}
+
 func (e *xmlElementImpl) SetText(inText string) {
 	if node := e.FirstChild(); (nil != node) && (nil != node.ToText()) {
 		node.SetValue(inText)
@@ -608,6 +1481,69 @@ func (e *xmlElementImpl) SetText(inText string) {
 	}
 }
 
+func (e *xmlElementImpl) SetCDATAText(inText string) {
+	if node := e.FirstChild(); (nil != node) && (nil != node.ToText()) {
+		node.SetValue(inText)
+		node.ToText().SetCDATA(true)
+	} else {
+		theText := NewText(inText)
+		theText.SetCDATA(true)
+		e.InsertFirstChild(theText)
+	}
+}
+
+func (e *xmlElementImpl) IsEmpty() bool {
+	for node := e.FirstChild(); nil != node; node = node.Next() {
+		if nil != node.ToElement() {
+			return false
+		}
+
+		if (nil != node.ToText()) && ("" != strings.TrimSpace(node.Value())) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *xmlElementImpl) HasElementChildren() bool {
+	return nil != e.FirstChildElement("")
+}
+
+func (e *xmlElementImpl) SameTag(other XMLElement) bool {
+	if nil == other {
+		return false
+	}
+
+	return (e.Name() == other.Name()) && attributesEqual(e, other, CompareOptions{})
+}
+
+// ElementIndex 返回本元素在同名兄弟元素中从1开始的序号,与XPath中item[3]的含义相同,也与Path()中的序号一致.
+// 没有同名兄弟元素或者没有父节点时返回1
+func (e *xmlElementImpl) ElementIndex() int {
+	// 同名按照nameMatches的规则判断:<item>之前的<a:item>也计算在内,<a:item>则只计算<a:item>,
+	// 这样Path()中的/root/item[2]交给Evaluate或者FirstChildElement("item")系列查找时能找回同一个元素
+	index := 1
+	for sibling := e.PrevElement(e.Name()); nil != sibling; sibling = sibling.PrevElement(e.Name()) {
+		index++
+	}
+
+	return index
+}
+
+// Unwrap 按顺序将所有子节点移动到本元素原来的位置上,然后删除本元素.本元素没有父节点时什么也不做
+func (e *xmlElementImpl) Unwrap() {
+	if nil == e.parent {
+		return
+	}
+
+	for _, child := range e.TakeChildren() {
+		e.parent.insertBeforeChild(e, child)
+	}
+
+	e.Split()
+}
+
 func (e *xmlElementImpl) ForeachAttribute(callback func(attribute XMLAttribute) int) int {
 	for elem := e.attrlist.Front(); nil != elem; elem = elem.Next() {
 		if ret := callback(elem.Value.(*xmlAttributeImpl)); 0 != ret {
@@ -629,6 +1565,10 @@ type xmlCommentImpl struct {
 	xmlNodeImpl
 }
 
+func (c *xmlCommentImpl) Type() NodeType {
+	return CommentNode
+}
+
 func (c *xmlCommentImpl) ToComment() XMLComment {
 	return c
 }
@@ -641,6 +1581,15 @@ func (c *xmlCommentImpl) SetComment(newComment string) {
 	c.value = newComment
 }
 
+func (c *xmlCommentImpl) SetCommentE(newComment string) error {
+	if !ValidComment(newComment) {
+		return errors.New("Invalid comment:" + newComment)
+	}
+
+	c.SetComment(newComment)
+	return nil
+}
+
 func (c *xmlCommentImpl) Accept(visitor XMLVisitor) bool {
 	return visitor.VisitComment(c)
 }
@@ -652,6 +1601,10 @@ type xmlProcInstImpl struct {
 	instruction string
 }
 
+func (p *xmlProcInstImpl) Type() NodeType {
+	return ProcInstNode
+}
+
 func (p *xmlProcInstImpl) ToProcInst() XMLProcInst {
 	return p
 }
@@ -674,6 +1627,10 @@ type xmlDocumentImpl struct {
 	xmlNodeImpl
 }
 
+func (d *xmlDocumentImpl) Type() NodeType {
+	return DocumentNode
+}
+
 func (d *xmlDocumentImpl) ToDocument() XMLDocument {
 	return d
 }
@@ -691,11 +1648,75 @@ func (d *xmlDocumentImpl) Accept(visitor XMLVisitor) bool {
 	return visitor.VisitExitDocument(d)
 }
 
+func (d *xmlDocumentImpl) RootElement() XMLElement {
+	return d.FirstChildElement("")
+}
+
+// Snapshot 深度复制整个文档,返回的文档与原文档不共享任何节点,可以交给其他goroutine独立读写
+func (d *xmlDocumentImpl) Snapshot() XMLDocument {
+	return CloneDocument(d)
+}
+
+func (d *xmlDocumentImpl) declarationParam(name string) string {
+	for node := d.FirstChild(); nil != node; node = node.Next() {
+		if procInst := node.ToProcInst(); (nil != procInst) && ("xml" == procInst.Target()) {
+			return procInstParam(procInst.Instruction(), name)
+		}
+	}
+
+	return ""
+}
+
+func (d *xmlDocumentImpl) Version() string {
+	return d.declarationParam("version")
+}
+
+func (d *xmlDocumentImpl) Encoding() string {
+	return d.declarationParam("encoding")
+}
+
+func (d *xmlDocumentImpl) Standalone() string {
+	return d.declarationParam("standalone")
+}
+
+// procInstParam 从处理指令的内容中读取形如name="value"或者name='value'的伪属性,找不到时返回空串
+func procInstParam(inst string, name string) string {
+	for {
+		inst = strings.TrimLeft(inst, " \t\r\n")
+		eq := strings.IndexByte(inst, '=')
+		if eq < 0 {
+			return ""
+		}
+
+		key := strings.TrimSpace(inst[:eq])
+		inst = strings.TrimLeft(inst[eq+1:], " \t\r\n")
+		if 0 == len(inst) || (('"' != inst[0]) && ('\'' != inst[0])) {
+			return ""
+		}
+
+		end := strings.IndexByte(inst[1:], inst[0])
+		if end < 0 {
+			return ""
+		}
+
+		if key == name {
+			return inst[1 : end+1]
+		}
+
+		inst = inst[end+2:]
+	}
+}
+
 // ------------------------------------------------------------------
 
 type xmlTextImpl struct {
 	xmlNodeImpl
 	cdata bool
+	raw   bool // 输出时不转义
+}
+
+func (t *xmlTextImpl) Type() NodeType {
+	return TextNode
 }
 
 func (t *xmlTextImpl) ToText() XMLText {
@@ -710,6 +1731,12 @@ func (t *xmlTextImpl) SetCDATA(isCData bool) {
 func (t *xmlTextImpl) CDATA() bool {
 	return t.cdata
 }
+func (t *xmlTextImpl) SetRaw(raw bool) {
+	t.raw = raw
+}
+func (t *xmlTextImpl) Raw() bool {
+	return t.raw
+}
 
 // ------------------------------------------------------------------
 
@@ -717,6 +1744,10 @@ type xmlDirectiveImpl struct {
 	xmlNodeImpl
 }
 
+func (d *xmlDirectiveImpl) Type() NodeType {
+	return DirectiveNode
+}
+
 func (d *xmlDirectiveImpl) ToDirective() XMLDirective {
 	return d
 }
@@ -725,41 +1756,242 @@ func (d *xmlDirectiveImpl) Accept(visitor XMLVisitor) bool {
 	return visitor.VisitDirective(d)
 }
 
-// ------------------------------------------------------------------
+// Doctype 是<!DOCTYPE ...>指令解析之后的结构化表示,由ParseDoctype生成
+type Doctype struct {
+	Name           string            // 根元素的名字
+	PublicID       string            // PUBLIC标识符,没有时为空串
+	SystemID       string            // SYSTEM标识符,通常是外部DTD的位置,没有时为空串
+	InternalSubset string            // [与]之间的内部子集的原始内容,没有时为空串
+	Entities       map[string]string // 内部子集中声明的通用实体,只包括直接给出替换文本的实体,不包括外部实体和参数实体
+}
 
-// NewText 创建一个新的XMLText对象
-func NewText(text string) XMLText {
-	node := new(xmlTextImpl)
-	node.implobj = node
-	node.value = text
-	return node
+// ParseDoctype 将一个DOCTYPE指令解析成Doctype,d不是DOCTYPE指令或者格式错误时返回错误.
+// 内部子集只解析其中的实体声明,其他的声明(ELEMENT、ATTLIST等)保留在InternalSubset中由调用者自行处理
+func ParseDoctype(d XMLDirective) (*Doctype, error) {
+	if nil == d {
+		return nil, errors.New("Directive is nil")
+	}
+
+	scanner := &doctypeScanner{s: d.Value()}
+	if !scanner.keyword("DOCTYPE") {
+		return nil, errors.New("Not a DOCTYPE directive:" + d.Value())
+	}
+
+	doctype := &Doctype{Entities: make(map[string]string)}
+	if doctype.Name = scanner.name(); "" == doctype.Name {
+		return nil, errors.New("DOCTYPE missing the root element name")
+	}
+
+	var ok bool
+	switch {
+	case scanner.keyword("PUBLIC"):
+		if doctype.PublicID, ok = scanner.quoted(); !ok {
+			return nil, errors.New("DOCTYPE missing the public identifier")
+		}
+		if doctype.SystemID, ok = scanner.quoted(); !ok {
+			return nil, errors.New("DOCTYPE missing the system identifier")
+		}
+	case scanner.keyword("SYSTEM"):
+		if doctype.SystemID, ok = scanner.quoted(); !ok {
+			return nil, errors.New("DOCTYPE missing the system identifier")
+		}
+	}
+
+	if scanner.skip("[") {
+		if doctype.InternalSubset, ok = scanner.internalSubset(); !ok {
+			return nil, errors.New("DOCTYPE internal subset not closed")
+		}
+		parseEntities(doctype.InternalSubset, doctype.Entities)
+	}
+
+	if scanner.skipSpace(); !scanner.eof() {
+		return nil, errors.New("Unexpected content in DOCTYPE:" + scanner.s[scanner.pos:])
+	}
+
+	return doctype, nil
 }
 
-// NewComment 创建一个新的XMLComment对象
-func NewComment(comment string) XMLComment {
-	node := new(xmlCommentImpl)
-	node.implobj = node
-	node.value = comment
-	return node
+// parseEntities 解析内部子集中的<!ENTITY name "value">声明,结果存放在entities中,同名实体以第一次声明的为准
+func parseEntities(subset string, entities map[string]string) {
+	for i := strings.Index(subset, "<!ENTITY"); i >= 0; i = strings.Index(subset, "<!ENTITY") {
+		scanner := &doctypeScanner{s: subset[i+len("<!ENTITY"):]}
+		subset = scanner.s
+
+		// 参数实体只在DTD内部使用,这里跳过
+		if scanner.skip("%") {
+			continue
+		}
+
+		name := scanner.name()
+		value, ok := scanner.quoted()
+		if ("" == name) || !ok {
+			continue
+		}
+
+		if _, exist := entities[name]; !exist {
+			entities[name] = value
+		}
+		subset = scanner.s[scanner.pos:]
+	}
 }
 
-// NewElement 创建一个新的XMLElement对象
-func NewElement(name string) XMLElement {
-	node := new(xmlElementImpl)
-	node.implobj = node
-	node.value = name
-	node.attrsmap = make(map[string]*list.Element)
-	node.attrlist = list.New()
-	return node
+// doctypeScanner 用于解析DOCTYPE指令的简单扫描器,除internalSubset之外的方法都会先跳过前导的空白
+type doctypeScanner struct {
+	s   string
+	pos int
 }
 
-// NewProcInst 创建一个新的XMLProcInst对象
-func NewProcInst(target string, inst string) XMLProcInst {
-	node := new(xmlProcInstImpl)
-	node.implobj = node
-	node.value = target
-	node.instruction = inst
-	return node
+func (s *doctypeScanner) eof() bool {
+	return s.pos >= len(s.s)
+}
+
+func (s *doctypeScanner) skipSpace() {
+	for !s.eof() && strings.IndexByte(" \t\r\n", s.s[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// skip 如果接下来的内容是prefix则跳过它并返回true
+func (s *doctypeScanner) skip(prefix string) bool {
+	s.skipSpace()
+	if strings.HasPrefix(s.s[s.pos:], prefix) {
+		s.pos += len(prefix)
+		return true
+	}
+
+	return false
+}
+
+// keyword 与skip类似,但是要求关键字之后是空白或者结束,避免把SYSTEMX这样的名字当成关键字
+func (s *doctypeScanner) keyword(word string) bool {
+	start := s.pos
+	if !s.skip(word) {
+		return false
+	}
+
+	if s.eof() || (strings.IndexByte(" \t\r\n", s.s[s.pos]) >= 0) {
+		return true
+	}
+
+	s.pos = start
+	return false
+}
+
+func (s *doctypeScanner) name() string {
+	s.skipSpace()
+	start := s.pos
+	for !s.eof() && (strings.IndexByte(" \t\r\n[>\"'", s.s[s.pos]) < 0) {
+		s.pos++
+	}
+
+	return s.s[start:s.pos]
+}
+
+// quoted 读取一个用单引号或者双引号括起来的字符串,返回其中的内容
+func (s *doctypeScanner) quoted() (string, bool) {
+	s.skipSpace()
+	if s.eof() || ((s.s[s.pos] != '"') && (s.s[s.pos] != '\'')) {
+		return "", false
+	}
+
+	end := strings.IndexByte(s.s[s.pos+1:], s.s[s.pos])
+	if end < 0 {
+		return "", false
+	}
+
+	value := s.s[s.pos+1 : s.pos+1+end]
+	s.pos += end + 2
+	return value, true
+}
+
+// internalSubset 读取到与[匹配的]为止的内容,引号和注释中的]不作为结束
+func (s *doctypeScanner) internalSubset() (string, bool) {
+	start := s.pos
+	for !s.eof() {
+		switch {
+		case strings.HasPrefix(s.s[s.pos:], "<!--"):
+			end := strings.Index(s.s[s.pos:], "-->")
+			if end < 0 {
+				return "", false
+			}
+			s.pos += end + len("-->")
+		case ('"' == s.s[s.pos]) || ('\'' == s.s[s.pos]):
+			if _, ok := s.quoted(); !ok {
+				return "", false
+			}
+		case ']' == s.s[s.pos]:
+			s.pos++
+			return s.s[start : s.pos-1], true
+		default:
+			s.pos++
+		}
+	}
+
+	return "", false
+}
+
+// ------------------------------------------------------------------
+
+// NewText 创建一个新的XMLText对象
+func NewText(text string) XMLText {
+	node := new(xmlTextImpl)
+	node.implobj = node
+	node.value = text
+	return node
+}
+
+// NewComment 创建一个新的XMLComment对象
+func NewComment(comment string) XMLComment {
+	node := new(xmlCommentImpl)
+	node.implobj = node
+	node.value = comment
+	return node
+}
+
+// NewCommentE 与NewComment相同,只是comment不是合法的注释内容时返回错误,参见ValidComment
+func NewCommentE(comment string) (XMLComment, error) {
+	if !ValidComment(comment) {
+		return nil, errors.New("Invalid comment:" + comment)
+	}
+
+	return NewComment(comment), nil
+}
+
+// NewElement 创建一个新的XMLElement对象
+func NewElement(name string) XMLElement {
+	node := new(xmlElementImpl)
+	node.implobj = node
+	node.value = name
+	node.attrsmap = make(map[string]*list.Element)
+	node.attrlist = list.New()
+	return node
+}
+
+// NewElementE 与NewElement相同,只是name不是合法的XML名字时返回错误,参见ValidName
+func NewElementE(name string) (XMLElement, error) {
+	if !ValidName(name) {
+		return nil, errors.New("Invalid element name:" + name)
+	}
+
+	return NewElement(name), nil
+}
+
+// NewProcInst 创建一个新的XMLProcInst对象
+func NewProcInst(target string, inst string) XMLProcInst {
+	node := new(xmlProcInstImpl)
+	node.implobj = node
+	node.value = target
+	node.instruction = inst
+	return node
+}
+
+// NewProcInstE 与NewProcInst相同,只是target不是合法的XML名字时返回错误
+func NewProcInstE(target string, inst string) (XMLProcInst, error) {
+	if !ValidName(target) {
+		return nil, errors.New("Invalid processing instruction target:" + target)
+	}
+
+	return NewProcInst(target, inst), nil
 }
 
 // NewDirective 创建一个新的XMLDirective对象
@@ -787,17 +2019,234 @@ func NewDocument() XMLDocument {
 	return doc
 }
 
+// CloneDocument 深度复制整个文档,包括声明、注释、指令以及根元素的整棵树.
+// 返回的文档与doc不共享任何节点,其中所有节点的Document()都指向新文档,适合反复复制同一份解析好的模板
+func CloneDocument(doc XMLDocument) XMLDocument {
+	if nil == doc {
+		return nil
+	}
+
+	clone := NewDocument()
+	cloneChildren(doc, clone)
+	return clone
+}
+
+const (
+	xmlNamespaceURI   = "http://www.w3.org/XML/1998/namespace"
+	xmlnsNamespaceURI = "http://www.w3.org/2000/xmlns/"
+)
+
+// qualifiedName 将xml.Name还原成带前缀的限定名,如:prefix:local
+func qualifiedName(name xml.Name) string {
+	if "" == name.Space {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// nameMatches 所有按名字查找元素和属性的地方(FirstChildElement、ElementIndex、Path、Evaluate、RenameElements、
+// Attribute、SetAttribute等)都使用这一个规则来判断名字actual是否与查找时使用的name匹配:
+//   - name为空串时匹配任意名字(只用于元素,如FirstChildElement(""))
+//   - name带前缀时要求完全相同
+//   - name不带前缀时与actual的本地名比较,所以"item"既匹配<item>也匹配<a:item>.名字空间声明xmlns:p不参与这种匹配
+//
+// 加载器保留名字空间前缀之前,Name()返回的就是本地名,这样原有的调用者读取带前缀的文档时行为不变.
+// 需要区分前缀时传入带前缀的名字,需要区分名字空间时使用FirstChildElementNS、AttributeNS
+func nameMatches(actual string, name string) bool {
+	if ("" == name) || (actual == name) {
+		return true
+	}
+
+	if strings.IndexByte(name, ':') >= 0 {
+		return false
+	}
+
+	prefix, local := splitQualifiedName(actual)
+	return ("xmlns" != prefix) && (local == name)
+}
+
+// splitQualifiedName 将限定名拆分成前缀和本地名两部分,没有前缀的名字其前缀为空串
+func splitQualifiedName(name string) (prefix string, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return "", name
+}
+
+// lookupNamespaceURI 从node开始逐级向上查找前缀prefix所绑定的名字空间URI,prefix为空串时查找的是缺省名字空间.
+// xml、xmlns两个前缀是XML规范预先绑定好的,找不到时返回空串
+func lookupNamespaceURI(node XMLNode, prefix string) string {
+	switch prefix {
+	case "xml":
+		return xmlNamespaceURI
+	case "xmlns":
+		return xmlnsNamespaceURI
+	}
+
+	attrName := namespaceAttributeName(prefix)
+	for ; nil != node; node = node.Parent() {
+		elem := node.ToElement()
+		if nil == elem {
+			continue
+		}
+
+		if attr := attributeExact(elem, attrName); nil != attr {
+			return attr.Value()
+		}
+	}
+
+	return ""
+}
+
+// NamespacesInScope 返回elem上生效的所有名字空间绑定,键为前缀(缺省名字空间的键为空串),值为名字空间URI.
+// 从elem开始逐级向上收集xmlns、xmlns:prefix声明,近处的声明覆盖远处的同名声明,被xmlns=""取消的缺省名字空间不包含在内.
+// XML规范预先绑定的xml前缀总是包含在结果中.elem为nil时返回nil
+func NamespacesInScope(elem XMLElement) map[string]string {
+	if nil == elem {
+		return nil
+	}
+
+	namespaces := map[string]string{"xml": xmlNamespaceURI}
+	declared := make(map[string]bool)
+	for node := XMLNode(elem); nil != node; node = node.Parent() {
+		e := node.ToElement()
+		if nil == e {
+			continue
+		}
+
+		e.ForeachAttribute(func(attr XMLAttribute) int {
+			prefix, local := splitQualifiedName(attr.Name())
+			switch {
+			case "xmlns" == attr.Name():
+				prefix = ""
+			case "xmlns" == prefix:
+				prefix = local
+			default:
+				return 0
+			}
+
+			if !declared[prefix] {
+				declared[prefix] = true
+				namespaces[prefix] = attr.Value()
+			}
+			return 0
+		})
+	}
+
+	if "" == namespaces[""] {
+		delete(namespaces, "")
+	}
+	return namespaces
+}
+
 type context struct {
 	doc           XMLDocument
 	parent        XMLNode
 	rootElemExist bool
+	decoder       *xml.Decoder
+	tokenStart    int64 // 当前token在输入流中的起始位置
+	fragment      bool  // 解析的是XML片段,允许没有或者有多个顶层元素,也允许顶层出现文本
+	options       LoadOptions
+	depth         int  // 当前元素的嵌套层数
+	stopAtRoot    bool // 根元素结束时立即返回,用于从同一个输入流中依次解析多个文档
+	converted     bool // 输入流已经由CharsetReader转换成了UTF-8,decoder的偏移量不再对应原始输入
+
+	tokens     TokenReader  // 直接从Token流构建文档时使用,这时decoder为nil,节点也没有在输入流中的范围
+	recorder   *rawRecorder // 只有设置了实体展开的限制时才需要记录原始字节
+	entities   int          // 已经展开的实体个数
+	entitySize int          // 已经展开的实体的替换文本的总字节数
+}
+
+// setSourceRange 记录node在输入流中的范围.输入流经过CharsetReader转换之后,decoder的偏移量是在转换之后的UTF-8流中的位置,
+// 与原始输入对不上,这时不记录范围,SourceRange返回-1,-1
+func (ctx *context) setSourceRange(node XMLNode, start int, end int) {
+	if !ctx.converted {
+		node.setSourceRange(start, end)
+	}
+}
+
+// rawRecorder 记录decoder从输入流中读取的原始字节,用于统计实体引用的展开情况
+type rawRecorder struct {
+	rd   io.Reader
+	buf  []byte
+	base int64 // buf[0]在输入流中的位置
+}
+
+func (r *rawRecorder) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// restart 丢弃offset之后已经读取的字节,改为从rd中读取并记录.用于CharsetReader转换编码之后,
+// offset之前的内容(XML声明)在转换前后是相同的
+func (r *rawRecorder) restart(offset int64, rd io.Reader) {
+	r.buf = r.buf[:offset-r.base]
+	r.rd = rd
+}
+
+// raw 返回输入流中[start,end)范围内的原始字节,start之前的字节不会再被用到,直接丢弃
+func (r *rawRecorder) raw(start int64, end int64) []byte {
+	r.buf = r.buf[start-r.base:]
+	r.base = start
+	return r.buf[:end-start]
+}
+
+// checkEntities 统计token中的实体引用,超过LoadOptions中设置的限制时返回错误
+func (ctx *context) checkEntities(token xml.Token) error {
+	switch token.(type) {
+	case xml.StartElement, xml.CharData:
+	default:
+		return nil
+	}
+
+	raw := ctx.recorder.raw(ctx.tokenStart, ctx.decoder.InputOffset())
+	if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+		return nil
+	}
+
+	for i := bytes.IndexByte(raw, '&'); i >= 0; i = bytes.IndexByte(raw, '&') {
+		raw = raw[i+1:]
+		end := bytes.IndexByte(raw, ';')
+		if (end < 0) || ((end > 0) && ('#' == raw[0])) {
+			continue
+		}
+
+		// 预定义的实体都只展开成一个字符
+		size := 1
+		if value, ok := ctx.options.Entity[string(raw[:end])]; ok {
+			size = len(value)
+		}
+
+		ctx.entities++
+		ctx.entitySize += size
+		if (ctx.options.MaxEntityExpansions > 0) && (ctx.entities > ctx.options.MaxEntityExpansions) {
+			return errors.New("Entity expansions exceed the limit " + strconv.Itoa(ctx.options.MaxEntityExpansions))
+		}
+
+		if (ctx.options.MaxEntityExpansionSize > 0) && (ctx.entitySize > ctx.options.MaxEntityExpansionSize) {
+			return errors.New("Entity expansion size exceeds the limit " + strconv.Itoa(ctx.options.MaxEntityExpansionSize))
+		}
+	}
+
+	return nil
+}
+
+// insert 将解析出来的节点添加到当前父节点下,并记录节点在输入流中的位置
+func (ctx *context) insert(node XMLNode) {
+	ctx.parent.InsertEndChild(node)
+	if nil != ctx.decoder {
+		ctx.setSourceRange(node, int(ctx.tokenStart), int(ctx.decoder.InputOffset()))
+	}
 }
 
 func handleStartElement(startElement xml.StartElement, ctx *context) error {
 	//startElement := token.(xml.StartElement)
 
 	// 一个XML文档只允许有唯一一个根节点
-	if ctx.doc == ctx.parent {
+	if (ctx.doc == ctx.parent) && !ctx.fragment {
 		if ctx.rootElemExist {
 			return errors.New("Root element has been exist:" + startElement.Name.Local)
 		}
@@ -806,136 +2255,645 @@ func handleStartElement(startElement xml.StartElement, ctx *context) error {
 		ctx.rootElemExist = true
 	}
 
-	node := NewElement(startElement.Name.Local)
+	// 防止恶意构造的深层嵌套文档
+	ctx.depth++
+	if (ctx.options.MaxDepth > 0) && (ctx.depth > ctx.options.MaxDepth) {
+		return errors.New("Element nesting depth " + strconv.Itoa(ctx.depth) + " exceeds the limit " +
+			strconv.Itoa(ctx.options.MaxDepth) + ":" + qualifiedName(startElement.Name))
+	}
+
+	node := NewElement(qualifiedName(startElement.Name))
 	for _, item := range startElement.Attr {
-		if nil != node.FindAttribute(item.Name.Local) {
-			return errors.New("Attributes have the same name:" + item.Name.Local)
+		name := qualifiedName(item.Name)
+		if nil != attributeExact(node, name) {
+			switch ctx.options.DuplicateAttributePolicy {
+			case DuplicateAttributeKeepFirst:
+				continue
+			case DuplicateAttributeKeepLast:
+			default:
+				return errors.New("Attributes have the same name:" + name)
+			}
 		}
-		node.SetAttribute(item.Name.Local, item.Value)
+		setAttributeExact(node, name, item.Value)
 	}
-	ctx.parent.InsertEndChild(node)
+	ctx.insert(node)
 	ctx.parent = node
 
 	return nil
 }
 
-func handleCharData(charData xml.CharData, ctx *context) error {
-	shortCharData := bytes.TrimSpace(charData)
-	if (nil != shortCharData) && (len(shortCharData) > 0) {
-		if ctx.doc == ctx.parent {
-			return errors.New("Text should be in the element")
-		}
-
-		node := NewText(string(charData))
-		ctx.parent.InsertEndChild(node)
+func handleEndElement(endElement xml.EndElement, ctx *context) error {
+	// RawToken不会检查开始标签和结束标签是否匹配,需要我们自己检查
+	name := qualifiedName(endElement.Name)
+	if ctx.doc == ctx.parent {
+		return errors.New("Unexpected end element:" + name)
 	}
 
-	return nil
-}
-
-// LoadDocument 从rd流中读取XML码流并构建成XMLDocument对象
-func LoadDocument(rd io.Reader) (XMLDocument, error) {
-
-	// 创建一个context
-	ctx := new(context)
-	ctx.doc = NewDocument()
-	ctx.parent = ctx.doc
-	ctx.rootElemExist = false
+	if elem := ctx.parent.ToElement(); (nil == elem) || (elem.Name() != name) {
+		return errors.New("Element <" + ctx.parent.Value() + "> closed by </" + name + ">")
+	}
 
-	// 创建一个decoder
-	decoder := xml.NewDecoder(rd)
-	token, err := decoder.Token()
+	// 设置了PreserveWhitespace或者在xml:space="preserve"的范围内时,所有的空白都是有意义的
+	if !ctx.options.PreserveWhitespace && !spacePreserved(ctx.parent) {
+		dropIndentation(ctx.parent)
+	}
 
-	for ; err == nil; token, err = decoder.Token() {
-		switch token.(type) {
-		case xml.StartElement:
-			err := handleStartElement(token.(xml.StartElement), ctx)
-			if nil != err {
-				return nil, err
-			}
-		case xml.EndElement:
-			ctx.parent = ctx.parent.Parent()
-		case xml.Comment:
-			ctx.parent.InsertEndChild(NewComment(string(token.(xml.Comment))))
-		case xml.Directive:
-			ctx.parent.InsertEndChild(NewDirective(string(token.(xml.Directive))))
-		case xml.ProcInst:
-			procInst := token.(xml.ProcInst)
-			ctx.parent.InsertEndChild(NewProcInst(procInst.Target, string(procInst.Inst)))
-		case xml.CharData:
-			if err := handleCharData(token.(xml.CharData), ctx); nil != err {
-				return nil, err
+	// 元素的范围需要延伸到结束标签.<a/>这样自闭合的元素,decoder在返回EndElement时不会再读取任何输入
+	if nil != ctx.decoder {
+		if impl, ok := ctx.parent.(*xmlElementImpl); ok {
+			impl.closeForm = closeFormEndTag
+			if ctx.decoder.InputOffset() == ctx.tokenStart {
+				impl.closeForm = closeFormSelfClose
 			}
-		default:
-			return nil, errors.New("Unsupported token type")
 		}
+
+		start, _ := ctx.parent.SourceRange()
+		ctx.setSourceRange(ctx.parent, start, int(ctx.decoder.InputOffset()))
 	}
+	ctx.parent = ctx.parent.Parent()
+	ctx.depth--
+	return nil
+}
 
-	if err == io.EOF {
-		// 不能是空文档
-		if nil == ctx.doc.FirstChildElement("") {
-			return nil, errors.New("XML document missing the root element")
+func handleCharData(charData xml.CharData, ctx *context) error {
+	if 0 == len(bytes.TrimSpace(charData)) {
+		// 顶层的空白直接丢弃(片段中保留所有空白时除外).元素内部的空白先保留下来,
+		// 等元素结束时再根据它的兄弟节点决定是否丢弃,参见dropIndentation
+		if (ctx.doc != ctx.parent) || (ctx.fragment && ctx.options.PreserveWhitespace) {
+			ctx.insert(NewText(string(charData)))
 		}
+		return nil
+	}
 
-		return ctx.doc, nil
+	if (ctx.doc == ctx.parent) && !ctx.fragment {
+		return errors.New("Text should be in the element")
 	}
 
-	return nil, err
+	node := NewText(string(charData))
+	ctx.insert(node)
+	return nil
 }
 
-func LoadDocumentFromFile(name string) (XMLDocument, error) {
-	file, err := os.Open(name)
-	if nil != err {
-		return nil, err
+// dropIndentation 在元素结束时删除其中只有空白的文本节点中用于缩进的部分,规则如下:
+//   - 元素含有非空白的文本(混合内容,如<p>a <b>b</b> <i>c</i></p>)时,所有的空白都是有意义的,全部保留
+//   - 否则含有换行的空白,以及第一个和最后一个子节点位置上的空白都被当作缩进删除
+//   - 剩下的是位于两个兄弟节点之间、不含换行的空白,如<p><b>b</b> <i>c</i></p>中的空格,予以保留
+func dropIndentation(elem XMLNode) {
+	for child := elem.FirstChild(); nil != child; child = child.Next() {
+		if (nil != child.ToText()) && ("" != strings.TrimSpace(child.Value())) {
+			return
+		}
 	}
-	defer file.Close()
 
-	return LoadDocument(file)
+	for child := elem.FirstChild(); nil != child; {
+		next := child.Next()
+		if (nil != child.ToText()) && ((nil == child.Prev()) || (nil == next) || strings.ContainsAny(child.Value(), "\r\n")) {
+			elem.DeleteChild(child)
+		}
+		child = next
+	}
 }
 
-// SaveDocumentToFile Print the xml-dom objects to the writer.
-func SaveDocument(doc XMLDocument, writer io.Writer, options PrintOptions) error {
-	doc.Accept(NewSimplePrinter(writer, options))
-	return nil
+// LoadOptions 加载选项,用于LoadDocumentWithOptions函数,用于控制XML文档的解析行为
+type LoadOptions struct {
+	// CharsetReader 用于将非UTF-8编码(如GBK、ISO-8859-1)的输入流转换成UTF-8,会被直接设置给xml.Decoder的CharsetReader.
+	// 为nil时只能解析UTF-8编码的文档.常见字符集的转换可以借助golang.org/x/text/encoding来实现
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// MaxDepth 元素的最大嵌套层数,根元素为第1层,超过时解析失败,用于防御恶意构造的深层嵌套文档.0表示不限制
+	MaxDepth int
+
+	// Entity 自定义实体,键为实体名,值为替换文本,会被直接设置给xml.Decoder的Entity
+	Entity map[string]string
+
+	// MaxEntityExpansions 文本和属性值中实体引用(不含&#NN;这种字符引用)的最大个数,超过时解析失败.0表示不限制
+	MaxEntityExpansions int
+
+	// MaxEntityExpansionSize 所有实体引用展开之后的替换文本的总字节数上限,超过时解析失败.0表示不限制
+	MaxEntityExpansionSize int
+
+	// DuplicateAttributePolicy 同一个元素中出现同名属性(按带前缀的名字比较)时的处理方式,缺省解析失败.
+	// 这样的文档并不合法,但是在实际中并不少见,需要尽量读取其中的内容时可以选择保留第一个或者最后一个值
+	DuplicateAttributePolicy DuplicateAttributePolicy
+
+	// AllowFragment 按照XML片段解析:允许没有或者有多个顶层元素,顶层也可以直接出现文本,
+	// 得到的文档的子节点就是片段中的所有顶层节点,参见ParseFragment
+	AllowFragment bool
+
+	// PreserveWhitespace 保留元素中所有只有空白的文本节点,不再按照dropIndentation的规则丢弃缩进,
+	// 如<p><b>x</b>\n<i>y</i></p>中的换行也会被保留,效果相当于给根元素设置了xml:space="preserve".
+	// 文档顶层(根元素之外)的空白不属于任何元素,仍然会被丢弃;按片段解析(AllowFragment)时也会保留
+	PreserveWhitespace bool
 }
 
-// SaveDocumentToFile Print the xml-dom objects to the file.
-func SaveDocumentToFile(doc XMLDocument, name string, options PrintOptions) error {
-	file, err := os.Create(name)
-	if nil != err {
-		return err
-	}
-	defer file.Close()
+// DuplicateAttributePolicy 元素中出现同名属性时的处理方式,参见LoadOptions.DuplicateAttributePolicy
+type DuplicateAttributePolicy int
 
-	doc.Accept(NewSimplePrinter(file, options))
-	return nil
+const (
+	DuplicateAttributeError     DuplicateAttributePolicy = iota // 解析失败
+	DuplicateAttributeKeepFirst                                 // 保留第一次出现的值,忽略后面的
+	DuplicateAttributeKeepLast                                  // 保留最后一次出现的值,属性的位置仍然是第一次出现的位置
+)
+
+// LoadDocument 从rd流中读取XML码流并构建成XMLDocument对象.
+//
+// 只有空白的文本节点如果只是用于缩进会被丢弃,混合内容中有意义的空白会被保留,具体规则参见dropIndentation.
+// xml:space="preserve"的元素及其子孙元素中(直到遇到xml:space="default")所有的空白都会被保留,
+// 需要保留整个文档中所有的空白时请使用LoadDocumentWithOptions并设置LoadOptions.PreserveWhitespace
+func LoadDocument(rd io.Reader) (XMLDocument, error) {
+	return LoadDocumentWithOptions(rd, LoadOptions{})
 }
 
-// DefaultVisitor 这个类的目的是简化编写定制扫描的visitor,使得我们不需要定制XMLVisitor的所有接口
-type DefaultVisitor struct {
-	EnterDocument func(XMLDocument) bool
-	ExitDocument  func(XMLDocument) bool
-	EnterElement  func(XMLElement) bool
-	ExitElement   func(XMLElement) bool
-	ProcInst      func(XMLProcInst) bool
-	Text          func(XMLText) bool
-	Comment       func(XMLComment) bool
-	Directive     func(XMLDirective) bool
+// LoadDocumentWithOptions 从rd流中读取XML码流并构建成XMLDocument对象,options用于控制解析行为
+func LoadDocumentWithOptions(rd io.Reader, options LoadOptions) (XMLDocument, error) {
+	return newContext(rd, options).document()
 }
 
-// VisitEnterDocument is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitEnterDocument(doc XMLDocument) bool {
-	if nil == v.EnterDocument {
-		return true
+// document 解析整个输入流并返回构建好的文档
+func (ctx *context) document() (XMLDocument, error) {
+	if err := ctx.parse(); nil != err {
+		return nil, err
 	}
 
-	return v.EnterDocument(doc)
-}
-
-// VisitExitDocument is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitExitDocument(doc XMLDocument) bool {
-	if nil == v.ExitDocument {
-		return true
+	// 不能是空文档
+	if !ctx.fragment && (nil == ctx.doc.FirstChildElement("")) {
+		return nil, errors.New("XML document missing the root element")
+	}
+
+	return ctx.doc, nil
+}
+
+// LoadDocuments 从rd流中依次读取多个首尾相接的XML文档,直到流结束,常用于处理日志等以流的形式连续输出的XML记录.
+//
+// 每个文档在根元素结束时结束,根元素之后的注释、处理指令等节点属于下一个文档,最后一个根元素之后剩下的节点属于最后一个文档.
+// 节点的SourceRange是相对于整个输入流的位置.rd中没有任何文档时返回空数组
+func LoadDocuments(rd io.Reader) ([]XMLDocument, error) {
+	ctx := newContext(rd, LoadOptions{})
+	ctx.stopAtRoot = true
+
+	docs := make([]XMLDocument, 0)
+	for {
+		start := ctx.decoder.InputOffset()
+		if err := ctx.parse(); nil != err {
+			return nil, err
+		}
+
+		// 没有读到根元素说明已经到了流的末尾
+		if nil == ctx.doc.FirstChildElement("") {
+			break
+		}
+
+		ctx.setSourceRange(ctx.doc, int(start), int(ctx.decoder.InputOffset()))
+		docs = append(docs, ctx.doc)
+
+		ctx.doc = NewDocument()
+		ctx.parent = ctx.doc
+		ctx.rootElemExist = false
+	}
+
+	rest := ctx.doc.TakeChildren()
+	if 0 == len(rest) {
+		return docs, nil
+	}
+
+	if 0 == len(docs) {
+		return nil, errors.New("XML document missing the root element")
+	}
+
+	last := docs[len(docs)-1]
+	for _, node := range rest {
+		last.InsertEndChild(node)
+	}
+	return docs, nil
+}
+
+// TokenReader 依次返回xml.Token的数据源,用于LoadDocumentFromTokenReader.
+// 它与Go 1.10加入的xml.TokenReader的方法集完全相同,*xml.Decoder以及任何xml.TokenReader都可以直接传入,
+// 这里单独定义是为了不提高本库要求的Go版本
+type TokenReader interface {
+	Token() (xml.Token, error)
+}
+
+// LoadDocumentFromTokens 直接用encoding/xml中的Token构建XMLDocument对象,参见LoadDocumentFromTokenReader
+func LoadDocumentFromTokens(tokens []xml.Token) (XMLDocument, error) {
+	return LoadDocumentFromTokenReader(&sliceTokenReader{tokens: tokens})
+}
+
+// LoadDocumentFromTokenReader 从rd中依次读取Token并构建XMLDocument对象,rd返回io.EOF时结束,
+// 文档的检查规则与LoadDocument相同.
+//
+// 元素名和属性名可以像xml.Decoder的RawToken那样将前缀放在Name.Space中,也可以像NodeTokens那样直接使用带前缀的Local.
+// 由于没有原始的字节流,得到的节点的SourceRange都是(-1, -1)
+func LoadDocumentFromTokenReader(rd TokenReader) (XMLDocument, error) {
+	ctx := new(context)
+	ctx.doc = NewDocument()
+	ctx.parent = ctx.doc
+	ctx.tokens = rd
+	return ctx.document()
+}
+
+// sliceTokenReader 将Token数组包装成TokenReader
+type sliceTokenReader struct {
+	tokens []xml.Token
+}
+
+func (r *sliceTokenReader) Token() (xml.Token, error) {
+	if 0 == len(r.tokens) {
+		return nil, io.EOF
+	}
+
+	token := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	return token, nil
+}
+
+// ParseFragment 解析一段XML片段,返回片段中所有的顶层节点,这些节点都是游离的,可以直接通过InsertEndChild等接口插入到文档中.
+// 与LoadDocument不同的是,片段可以没有或者有多个顶层元素,顶层也可以直接出现文本
+func ParseFragment(xmlText string) ([]XMLNode, error) {
+	ctx := newContext(strings.NewReader(xmlText), LoadOptions{AllowFragment: true})
+	if err := ctx.parse(); nil != err {
+		return nil, err
+	}
+
+	return ctx.doc.TakeChildren(), nil
+}
+
+// newContext 创建一个用于从rd中解析XML的context
+func newContext(rd io.Reader, options LoadOptions) *context {
+	ctx := new(context)
+	ctx.init(rd, options, nil)
+	return ctx
+}
+
+// init 初始化ctx用于从rd中解析XML.buffer不为nil时用它作为decoder的读缓冲区,避免每次都重新分配
+func (ctx *context) init(rd io.Reader, options LoadOptions, buffer *bufio.Reader) {
+	*ctx = context{}
+	ctx.doc = NewDocument()
+	ctx.parent = ctx.doc
+	ctx.rootElemExist = false
+	ctx.fragment = options.AllowFragment
+	ctx.options = options
+
+	if (options.MaxEntityExpansions > 0) || (options.MaxEntityExpansionSize > 0) {
+		ctx.recorder = &rawRecorder{rd: rd}
+		rd = ctx.recorder
+	}
+
+	// decoder要求输入是io.ByteReader,否则会自己再包装一个bufio.Reader
+	if nil != buffer {
+		buffer.Reset(rd)
+		rd = buffer
+	}
+
+	// 创建一个decoder,使用RawToken读取是为了保留元素和属性的名字空间前缀
+	ctx.decoder = xml.NewDecoder(rd)
+	if nil != options.CharsetReader {
+		ctx.decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			ctx.converted = true
+			converted, err := options.CharsetReader(charset, input)
+			if (nil != err) || (nil == ctx.recorder) {
+				return converted, err
+			}
+
+			// 之后decoder的偏移量都是转换之后的流中的位置,所以从这里开始改为记录转换之后的内容
+			ctx.recorder.restart(ctx.decoder.InputOffset(), converted)
+			return ctx.recorder, nil
+		}
+	}
+	ctx.decoder.Entity = options.Entity
+}
+
+// Parser 可以重复使用的XML解析器,适合在循环中解析大量的小文档.
+//
+// 与LoadDocumentWithOptions相比,Parser通过sync.Pool复用解析过程中的context和读缓冲区,减少的是每次解析分配的字节数,
+// 而不是分配的次数:xml.Decoder无法重置,每次解析仍然要创建一个新的,DOM树的节点也都是新分配的.
+// 输入流不是io.ByteReader(如文件、网络连接)时,xml.Decoder每次都要分配一个4KB的读缓冲区,复用它之后
+// Benchmark_Parser_Small中每次解析分配的内存从约6KB降到约2KB,分配次数只少了两次;
+// strings.Reader、bytes.Reader这样的输入本来就不需要额外的缓冲区,基本没有收益.
+// 同一个Parser可以被多个goroutine同时使用
+type Parser struct {
+	options LoadOptions
+	pool    sync.Pool
+}
+
+// parserState Parser在两次解析之间复用的状态
+type parserState struct {
+	ctx    context
+	buffer *bufio.Reader
+}
+
+// NewParser 创建一个使用options解析文档的Parser
+func NewParser(options LoadOptions) *Parser {
+	return &Parser{options: options}
+}
+
+// Parse 从rd流中读取XML码流并构建成XMLDocument对象,与LoadDocumentWithOptions的行为相同
+func (p *Parser) Parse(rd io.Reader) (XMLDocument, error) {
+	state, _ := p.pool.Get().(*parserState)
+	if nil == state {
+		state = &parserState{buffer: bufio.NewReader(nil)}
+	}
+
+	defer func() {
+		// 不能让池中的对象继续引用已经返回给调用者的文档和输入流
+		state.ctx = context{}
+		state.buffer.Reset(nil)
+		p.pool.Put(state)
+	}()
+
+	state.ctx.init(rd, p.options, state.buffer)
+	return state.ctx.document()
+}
+
+// parse 读取输入流中所有的token,并构建成DOM树挂在ctx.doc下
+func (ctx *context) parse() error {
+	// 读取token之前先记下当前的位置,用于记录节点在输入流中的范围
+	next := func() (xml.Token, error) {
+		if nil == ctx.decoder {
+			return ctx.tokens.Token()
+		}
+
+		ctx.tokenStart = ctx.decoder.InputOffset()
+		return ctx.decoder.RawToken()
+	}
+
+	token, err := next()
+	for ; err == nil; token, err = next() {
+		if nil != ctx.recorder {
+			if err := ctx.checkEntities(token); nil != err {
+				return err
+			}
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			err := handleStartElement(token.(xml.StartElement), ctx)
+			if nil != err {
+				return err
+			}
+		case xml.EndElement:
+			if err := handleEndElement(token.(xml.EndElement), ctx); nil != err {
+				return err
+			}
+
+			if ctx.stopAtRoot && (ctx.doc == ctx.parent) {
+				return nil
+			}
+		case xml.Comment:
+			ctx.insert(NewComment(string(token.(xml.Comment))))
+		case xml.Directive:
+			ctx.insert(NewDirective(string(token.(xml.Directive))))
+		case xml.ProcInst:
+			procInst := token.(xml.ProcInst)
+			ctx.insert(NewProcInst(procInst.Target, string(procInst.Inst)))
+		case xml.CharData:
+			if err := handleCharData(token.(xml.CharData), ctx); nil != err {
+				return err
+			}
+		default:
+			return errors.New("Unsupported token type")
+		}
+	}
+
+	if err != io.EOF {
+		return err
+	}
+
+	// 还有元素没有关闭
+	if ctx.doc != ctx.parent {
+		return errors.New("Unexpected EOF, element not closed:" + ctx.parent.Value())
+	}
+
+	if nil != ctx.decoder {
+		ctx.setSourceRange(ctx.doc, 0, int(ctx.decoder.InputOffset()))
+	}
+	return nil
+}
+
+func LoadDocumentFromFile(name string) (XMLDocument, error) {
+	file, err := os.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadDocument(file)
+}
+
+// LoadDocumentGzip 从gzip压缩过的rd流中读取XML码流并构建成XMLDocument对象
+func LoadDocumentGzip(rd io.Reader) (XMLDocument, error) {
+	zr, err := gzip.NewReader(rd)
+	if nil != err {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return LoadDocument(zr)
+}
+
+// gzipMagic gzip文件开头的两个字节
+var gzipMagic = []byte{0x1F, 0x8B}
+
+// LoadDocumentFromFileAuto 与LoadDocumentFromFile相同,只是会根据文件开头的内容自动识别gzip压缩过的文件(如.xml.gz),
+// 并在解析之前透明地解压,不依赖于文件的扩展名
+func LoadDocumentFromFileAuto(name string) (XMLDocument, error) {
+	file, err := os.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+
+	rd := bufio.NewReader(file)
+	if magic, _ := rd.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		return LoadDocumentGzip(rd)
+	}
+
+	return LoadDocument(rd)
+}
+
+// SaveDocument Print the xml-dom objects to the writer.
+//
+// 打印过程中会有大量零碎的小块写入,所以内部会先写到bufio.Writer中,结束时再一次性Flush到writer,
+// 写入过程中出现的错误也会在Flush时返回
+func SaveDocument(doc XMLDocument, writer io.Writer, options PrintOptions) error {
+	buffered := bufio.NewWriter(writer)
+	printer := NewSimplePrinter(buffered, options)
+	doc.Accept(printer)
+	if err := printError(printer); nil != err {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+// SaveDocumentToFile Print the xml-dom objects to the file.
+func SaveDocumentToFile(doc XMLDocument, name string, options PrintOptions) error {
+	file, err := os.Create(name)
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+
+	return SaveDocument(doc, file, options)
+}
+
+// NodeToString 将node及其所有子孙节点序列化成字符串,node可以是任意类型的节点,常用于调试、日志输出和测试断言
+func NodeToString(node XMLNode, options PrintOptions) string {
+	if nil == node {
+		return ""
+	}
+
+	buf := bytes.NewBufferString("")
+	node.Accept(NewSimplePrinter(buf, options))
+	return buf.String()
+}
+
+// SerializedSize 返回NodeToString(node, options)输出的字节数,已经考虑了转义、缩进、自闭合等所有选项的影响.
+// 只统计字节数而不保存输出的内容,可以用于预先分配缓冲区,或者在输出之前检查大小是否超出限制
+func SerializedSize(node XMLNode, options PrintOptions) int {
+	if nil == node {
+		return 0
+	}
+
+	counter := &sizeCounter{}
+	node.Accept(NewSimplePrinter(counter, options))
+	return counter.size
+}
+
+// sizeCounter 只统计写入的字节数的io.Writer
+type sizeCounter struct {
+	size int
+}
+
+func (c *sizeCounter) Write(p []byte) (int, error) {
+	c.size += len(p)
+	return len(p), nil
+}
+
+// InnerXML 只序列化elem的所有子节点,不含elem自身的开始和结束标签,相当于HTML中的innerHTML.
+// 只含文本的元素返回的是转义之后的文本(如a &amp; b),而不是Text()返回的原始内容;
+// 与输出整个元素时一样,含有文本的元素在缩进模式下内部也不折行不缩进
+func InnerXML(elem XMLElement, options PrintOptions) string {
+	buf := bytes.NewBufferString("")
+	WriteInnerXML(elem, buf, options)
+	return buf.String()
+}
+
+// WriteInnerXML 与InnerXML相同,只是将结果输出到writer,返回写入过程中出现的错误
+func WriteInnerXML(elem XMLElement, writer io.Writer, options PrintOptions) error {
+	if nil == elem {
+		return nil
+	}
+
+	buffered := bufio.NewWriter(writer)
+	printer := NewSimplePrinter(buffered, options)
+	if simple, ok := printer.(*xmlSimplePrinter); ok && (hasTextChild(elem) || spacePreserved(elem)) {
+		simple.lineHold++
+	}
+
+	for child := elem.FirstChild(); nil != child; child = child.Next() {
+		if !child.Accept(printer) {
+			break
+		}
+	}
+
+	if err := printError(printer); nil != err {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+// NodeTokens 将node及其所有子孙节点按文档顺序转换成encoding/xml中的Token,便于与基于xml.Encoder、xml.Token的代码对接.
+//
+// 元素名和属性名都原样(带前缀)放在xml.Name.Local中,Space总是为空串,因为xml.Encoder会把Space当成名字空间URI来处理,
+// 名字空间声明仍然以xmlns、xmlns:prefix属性的形式保留.CDATA和Raw文本都转换成普通的xml.CharData
+func NodeTokens(node XMLNode) []xml.Token {
+	var tokens []xml.Token
+	if nil == node {
+		return tokens
+	}
+
+	node.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			start := xml.StartElement{Name: xml.Name{Local: elem.Name()}}
+			elem.ForeachAttribute(func(attr XMLAttribute) int {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attr.Name()}, Value: attr.Value()})
+				return 0
+			})
+			tokens = append(tokens, start)
+			return true
+		},
+		ExitElement: func(elem XMLElement) bool {
+			tokens = append(tokens, xml.EndElement{Name: xml.Name{Local: elem.Name()}})
+			return true
+		},
+		ProcInst: func(procInst XMLProcInst) bool {
+			tokens = append(tokens, xml.ProcInst{Target: procInst.Target(), Inst: []byte(procInst.Instruction())})
+			return true
+		},
+		Text: func(text XMLText) bool {
+			tokens = append(tokens, xml.CharData(text.Value()))
+			return true
+		},
+		Comment: func(comment XMLComment) bool {
+			tokens = append(tokens, xml.Comment(comment.Value()))
+			return true
+		},
+		Directive: func(directive XMLDirective) bool {
+			tokens = append(tokens, xml.Directive(directive.Value()))
+			return true
+		},
+	})
+
+	return tokens
+}
+
+// SetInnerXML 用xmlText解析出来的节点替换elem原有的所有子节点,是InnerXML的逆操作,相当于给HTML中的innerHTML赋值.
+// xmlText解析失败时返回错误,elem原有的子节点保持不变
+func SetInnerXML(elem XMLElement, xmlText string) error {
+	if nil == elem {
+		return errors.New("Element is nil")
+	}
+
+	nodes, err := ParseFragment(xmlText)
+	if nil != err {
+		return err
+	}
+
+	elem.DeleteChildren()
+	for _, node := range nodes {
+		elem.InsertEndChild(node)
+	}
+
+	return nil
+}
+
+// DefaultVisitor 这个类的目的是简化编写定制扫描的visitor,使得我们不需要定制XMLVisitor的所有接口
+type DefaultVisitor struct {
+	EnterDocument func(XMLDocument) bool
+	ExitDocument  func(XMLDocument) bool
+	EnterElement  func(XMLElement) bool
+	ExitElement   func(XMLElement) bool
+	ProcInst      func(XMLProcInst) bool
+	Text          func(XMLText) bool
+	Comment       func(XMLComment) bool
+	Directive     func(XMLDirective) bool
+}
+
+// VisitEnterDocument is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitEnterDocument(doc XMLDocument) bool {
+	if nil == v.EnterDocument {
+		return true
+	}
+
+	return v.EnterDocument(doc)
+}
+
+// VisitExitDocument is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitExitDocument(doc XMLDocument) bool {
+	if nil == v.ExitDocument {
+		return true
 	}
 
 	return v.ExitDocument(doc)
@@ -947,188 +2905,2090 @@ func (v *DefaultVisitor) VisitEnterElement(elem XMLElement) bool {
 		return true
 	}
 
-	return v.EnterElement(elem)
+	return v.EnterElement(elem)
+}
+
+// VisitExitElement is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitExitElement(elem XMLElement) bool {
+	if nil == v.ExitElement {
+		return true
+	}
+
+	return v.ExitElement(elem)
+}
+
+// VisitProcInst is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitProcInst(pi XMLProcInst) bool {
+	if nil == v.ProcInst {
+		return true
+	}
+
+	return v.ProcInst(pi)
+}
+
+// VisitText is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitText(text XMLText) bool {
+	if nil == v.Text {
+		return true
+	}
+
+	return v.Text(text)
+}
+
+// VisitComment is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitComment(c XMLComment) bool {
+	if nil == v.Comment {
+		return true
+	}
+
+	return v.Comment(c)
+}
+
+// VisitDirective is the default implement of XMLVisitor
+func (v *DefaultVisitor) VisitDirective(d XMLDirective) bool {
+	if nil == v.Directive {
+		return true
+	}
+
+	return v.Directive(d)
+}
+
+// BaseVisitor 所有接口都直接返回true的XMLVisitor,用于嵌入到自定义的visitor中,只重写需要的接口即可,
+// 是DefaultVisitor之外的另一种写法:
+//
+//	type counter struct {
+//		tinydom.BaseVisitor
+//		elements int
+//	}
+//
+//	func (c *counter) VisitEnterElement(elem tinydom.XMLElement) bool {
+//		c.elements++
+//		return true
+//	}
+type BaseVisitor struct{}
+
+// VisitEnterDocument is the default implement of XMLVisitor
+func (BaseVisitor) VisitEnterDocument(XMLDocument) bool {
+	return true
+}
+
+// VisitExitDocument is the default implement of XMLVisitor
+func (BaseVisitor) VisitExitDocument(XMLDocument) bool {
+	return true
+}
+
+// VisitEnterElement is the default implement of XMLVisitor
+func (BaseVisitor) VisitEnterElement(XMLElement) bool {
+	return true
+}
+
+// VisitExitElement is the default implement of XMLVisitor
+func (BaseVisitor) VisitExitElement(XMLElement) bool {
+	return true
+}
+
+// VisitProcInst is the default implement of XMLVisitor
+func (BaseVisitor) VisitProcInst(XMLProcInst) bool {
+	return true
+}
+
+// VisitText is the default implement of XMLVisitor
+func (BaseVisitor) VisitText(XMLText) bool {
+	return true
+}
+
+// VisitComment is the default implement of XMLVisitor
+func (BaseVisitor) VisitComment(XMLComment) bool {
+	return true
+}
+
+// VisitDirective is the default implement of XMLVisitor
+func (BaseVisitor) VisitDirective(XMLDirective) bool {
+	return true
+}
+
+// ------------------------------------------------------------------
+
+// AllComments 按照文档顺序返回root子树中所有的注释,包括root自身.需要报告注释所在的位置时可以使用它们的Path()
+func AllComments(root XMLNode) []XMLComment {
+	var result []XMLComment
+	if nil == root {
+		return result
+	}
+
+	root.Accept(&DefaultVisitor{
+		Comment: func(c XMLComment) bool {
+			result = append(result, c)
+			return true
+		},
+	})
+
+	return result
+}
+
+// AllDirectives 按照文档顺序返回root子树中所有的指令(如DOCTYPE),包括root自身
+func AllDirectives(root XMLNode) []XMLDirective {
+	var result []XMLDirective
+	if nil == root {
+		return result
+	}
+
+	root.Accept(&DefaultVisitor{
+		Directive: func(d XMLDirective) bool {
+			result = append(result, d)
+			return true
+		},
+	})
+
+	return result
+}
+
+// AllProcInsts 按照文档顺序返回root子树中所有的处理指令,包括XML声明以及root自身
+func AllProcInsts(root XMLNode) []XMLProcInst {
+	var result []XMLProcInst
+	if nil == root {
+		return result
+	}
+
+	root.Accept(&DefaultVisitor{
+		ProcInst: func(pi XMLProcInst) bool {
+			result = append(result, pi)
+			return true
+		},
+	})
+
+	return result
+}
+
+// ReplaceTextFunc 对root子树中的每个文本节点(包括root自身)调用fn,并用fn的返回值替换文本的内容,常用于替换{{name}}这样的模板变量.
+// CDATA和Raw文本同样会被处理,它们的标记保持不变;属性值不受影响,需要时请使用ReplaceAttributeValues
+func ReplaceTextFunc(root XMLNode, fn func(string) string) {
+	if nil == root {
+		return
+	}
+
+	root.Accept(&DefaultVisitor{
+		Text: func(text XMLText) bool {
+			text.SetValue(fn(text.Value()))
+			return true
+		},
+	})
+}
+
+// ReplaceAttributeValues 对root子树中每个元素(包括root自身)的每个属性值调用fn,并用fn的返回值替换属性值.
+// 名字空间声明(xmlns、xmlns:prefix属性)也是属性,同样会被处理
+func ReplaceAttributeValues(root XMLNode, fn func(string) string) {
+	if nil == root {
+		return
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			elem.ForeachAttribute(func(attr XMLAttribute) int {
+				attr.SetValue(fn(attr.Value()))
+				return 0
+			})
+			return true
+		},
+	})
+}
+
+// Evaluate 计算一个简化版XPath表达式的值,用于在配置驱动的工具中查询文档而不必为每个路径编写代码.支持的表达式有:
+//
+//   - 路径:/root/item、//item、item/name(相对于root)、..、.,以及以@name、@*结尾的属性步骤
+//   - 节点测试:元素名(包括带前缀的名字)、*、node()、text()、comment()、processing-instruction()、directive();元素名和属性名与FirstChildElement、Attribute一样,不带前缀时只比较本地名
+//   - 谓词:[n]、[last()]、[@name]、[@name='v']、[child]、[child='v'],可以有多个,依次过滤;位置谓词相对于每个上下文节点的子节点计算,//item[1]会选中每个父元素下的第一个item
+//   - 函数:count(path)、string(path)、number(path)
+//
+// 路径的结果是按照文档顺序排列的[]XMLNode,以属性步骤结尾的路径返回[]XMLAttribute;count和number返回float64,
+// number在内容不是数字时返回NaN;string返回第一个节点的字符串值(元素是其中所有文本拼接起来的结果),没有节点时返回空串.
+// 以/开头的路径从root所在的文档开始匹配,游离的子树从最上层的节点开始匹配,与Path()的结果一致.不支持的表达式返回错误
+func Evaluate(root XMLNode, expr string) (interface{}, error) {
+	if nil == root {
+		return nil, errors.New("Root node must not be nil")
+	}
+
+	expr = strings.TrimSpace(expr)
+	for _, fn := range []string{"count", "string", "number"} {
+		if !strings.HasPrefix(expr, fn+"(") || !strings.HasSuffix(expr, ")") {
+			continue
+		}
+
+		nodes, attrs, err := evaluatePath(root, strings.TrimSpace(expr[len(fn)+1:len(expr)-1]))
+		if nil != err {
+			return nil, err
+		}
+
+		if "count" == fn {
+			return float64(len(nodes) + len(attrs)), nil
+		}
+
+		value := ""
+		if len(attrs) > 0 {
+			value = attrs[0].Value()
+		} else if len(nodes) > 0 {
+			value = stringValue(nodes[0])
+		}
+
+		if "string" == fn {
+			return value, nil
+		}
+
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if nil != err {
+			return math.NaN(), nil
+		}
+		return number, nil
+	}
+
+	nodes, attrs, err := evaluatePath(root, expr)
+	if nil != err {
+		return nil, err
+	}
+
+	if nil != attrs {
+		return attrs, nil
+	}
+	return nodes, nil
+}
+
+// xpathStep Evaluate中路径的一个步骤
+type xpathStep struct {
+	descendant bool     // 步骤之前是//
+	test       string   // 节点测试,如item、*、text()、@id
+	predicates []string // 谓词,不含两边的方括号
+}
+
+// parseXPath 将路径拆分成步骤
+func parseXPath(expr string) (absolute bool, steps []xpathStep, err error) {
+	if "" == expr {
+		return false, nil, errors.New("Invalid expression:" + expr)
+	}
+
+	absolute = strings.HasPrefix(expr, "/")
+	rest := expr
+	for "" != rest {
+		step := xpathStep{}
+		if strings.HasPrefix(rest, "//") {
+			step.descendant = true
+			rest = rest[2:]
+		} else if strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		}
+
+		if ("" == rest) && (0 == len(steps)) && absolute && !step.descendant {
+			return true, nil, nil
+		}
+
+		// 找到下一个不在方括号和引号中的/
+		end, depth, quote := len(rest), 0, byte(0)
+		for i := 0; i < len(rest); i++ {
+			c := rest[i]
+			switch {
+			case 0 != quote:
+				if c == quote {
+					quote = 0
+				}
+			case ('\'' == c) || ('"' == c):
+				quote = c
+			case '[' == c:
+				depth++
+			case ']' == c:
+				depth--
+			case ('/' == c) && (0 == depth):
+				end = i
+			}
+			if end == i {
+				break
+			}
+		}
+
+		text := strings.TrimSpace(rest[:end])
+		rest = rest[end:]
+		if open := strings.IndexByte(text, '['); open >= 0 {
+			if step.predicates, err = splitPredicates(text[open:]); nil != err {
+				return false, nil, errors.New("Invalid expression:" + expr)
+			}
+			text = strings.TrimSpace(text[:open])
+		}
+
+		if !validStepTest(text) {
+			return false, nil, errors.New("Invalid expression:" + expr)
+		}
+		step.test = text
+		steps = append(steps, step)
+	}
+
+	return absolute, steps, nil
+}
+
+// validStepTest 判断test是否是Evaluate支持的节点测试:元素名、*、.、..、@name、@*以及node()等节点类型.
+// 轴(如child::item)、运算符、|以及不支持的函数调用都会被拒绝,避免把写错的表达式当成没有匹配到节点
+func validStepTest(test string) bool {
+	switch test {
+	case "*", ".", "..", "@*", "node()", "text()", "comment()", "processing-instruction()", "directive()":
+		return true
+	}
+
+	return validXPathName(strings.TrimPrefix(test, "@"))
+}
+
+// validXPathName 判断name是否可以作为Evaluate中的元素名或者属性名.ValidName允许:出现在任意位置,
+// 这里还要排除轴使用的::以及以:开头或者结尾的名字
+func validXPathName(name string) bool {
+	return ValidName(name) && !strings.Contains(name, "::") &&
+		!strings.HasPrefix(name, ":") && !strings.HasSuffix(name, ":")
+}
+
+// splitPredicates 将[a][b='x]']这样的字符串拆分成各个谓词,引号中的方括号不参与匹配
+func splitPredicates(s string) ([]string, error) {
+	var predicates []string
+	start, depth, quote := 0, 0, byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 0 != quote:
+			if c == quote {
+				quote = 0
+			}
+		case ('\'' == c) || ('"' == c):
+			quote = c
+		case '[' == c:
+			if 0 == depth {
+				start = i + 1
+			}
+			depth++
+		case ']' == c:
+			depth--
+			if depth < 0 {
+				return nil, errors.New("Unbalanced brackets:" + s)
+			}
+			if 0 == depth {
+				predicates = append(predicates, strings.TrimSpace(s[start:i]))
+			}
+		case (0 == depth) && (' ' != c):
+			return nil, errors.New("Unexpected character after predicate:" + s)
+		}
+	}
+
+	if (0 != depth) || (0 != quote) {
+		return nil, errors.New("Unbalanced brackets:" + s)
+	}
+	return predicates, nil
+}
+
+// evaluatePath 计算路径表达式,以属性步骤结尾时返回的是属性,否则返回的是节点
+func evaluatePath(root XMLNode, expr string) ([]XMLNode, []XMLAttribute, error) {
+	absolute, steps, err := parseXPath(expr)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	// 游离子树的最上层节点没有父节点,用nil表示它上面虚拟的根,使得/x能够匹配到最上层的x
+	top := root
+	for nil != top.Parent() {
+		top = top.Parent()
+	}
+
+	contexts := []XMLNode{root}
+	if absolute {
+		contexts = []XMLNode{top}
+		if nil == top.ToDocument() {
+			contexts = []XMLNode{nil}
+		}
+	}
+
+	children := func(node XMLNode) []XMLNode {
+		if nil == node {
+			return []XMLNode{top}
+		}
+
+		var result []XMLNode
+		for child := node.FirstChild(); nil != child; child = child.Next() {
+			result = append(result, child)
+		}
+		return result
+	}
+
+	for i, step := range steps {
+		if strings.HasPrefix(step.test, "@") {
+			if (i != len(steps)-1) || (len(step.predicates) > 0) {
+				return nil, nil, errors.New("Unsupported attribute step:" + expr)
+			}
+			return nil, selectAttributes(expandDescendants(contexts, step.descendant, children), step.test[1:]), nil
+		}
+
+		selected := make(map[XMLNode]bool)
+		for _, context := range expandDescendants(contexts, step.descendant, children) {
+			var candidates []XMLNode
+			switch step.test {
+			case ".":
+				candidates = []XMLNode{context}
+			case "..":
+				if (nil != context) && (nil != context.Parent()) {
+					candidates = []XMLNode{context.Parent()}
+				}
+			default:
+				for _, child := range children(context) {
+					if matchNodeTest(child, step.test) {
+						candidates = append(candidates, child)
+					}
+				}
+			}
+
+			for _, predicate := range step.predicates {
+				if candidates, err = filterPredicate(candidates, predicate); nil != err {
+					return nil, nil, err
+				}
+			}
+
+			for _, node := range candidates {
+				if nil != node {
+					selected[node] = true
+				}
+			}
+		}
+
+		contexts = documentOrder(top, selected)
+	}
+
+	// 单独的/选中的是文档本身
+	if 0 == len(steps) {
+		return []XMLNode{top}, nil, nil
+	}
+
+	return contexts, nil, nil
+}
+
+// expandDescendants 步骤之前是//时,将每个上下文节点扩展成它自身以及所有的子孙节点
+func expandDescendants(contexts []XMLNode, descendant bool, children func(XMLNode) []XMLNode) []XMLNode {
+	if !descendant {
+		return contexts
+	}
+
+	var result []XMLNode
+	seen := make(map[XMLNode]bool)
+	var walk func(node XMLNode)
+	walk = func(node XMLNode) {
+		// 上下文节点是按照文档顺序排列的,已经访问过的节点其子孙节点也都已经访问过了
+		if seen[node] {
+			return
+		}
+
+		seen[node] = true
+		result = append(result, node)
+		for _, child := range children(node) {
+			walk(child)
+		}
+	}
+
+	for _, context := range contexts {
+		walk(context)
+	}
+
+	return result
+}
+
+// documentOrder 按照文档顺序返回top子树中所有在selected中的节点
+func documentOrder(top XMLNode, selected map[XMLNode]bool) []XMLNode {
+	result := make([]XMLNode, 0, len(selected))
+	if 0 == len(selected) {
+		return result
+	}
+
+	var walk func(node XMLNode)
+	walk = func(node XMLNode) {
+		if selected[node] {
+			result = append(result, node)
+		}
+
+		for child := node.FirstChild(); nil != child; child = child.Next() {
+			walk(child)
+		}
+	}
+	walk(top)
+
+	return result
+}
+
+// matchNodeTest 判断node是否满足节点测试test
+func matchNodeTest(node XMLNode, test string) bool {
+	switch test {
+	case "node()":
+		return true
+	case "*":
+		return ElementNode == node.Type()
+	case "text()":
+		return TextNode == node.Type()
+	case "comment()":
+		return CommentNode == node.Type()
+	case "processing-instruction()":
+		return ProcInstNode == node.Type()
+	case "directive()":
+		return DirectiveNode == node.Type()
+	}
+
+	return (ElementNode == node.Type()) && nameMatches(node.Value(), test)
+}
+
+// filterPredicate 用谓词过滤candidates,位置谓词按照candidates中的顺序从1开始计算
+func filterPredicate(candidates []XMLNode, predicate string) ([]XMLNode, error) {
+	if "last()" == predicate {
+		if 0 == len(candidates) {
+			return nil, nil
+		}
+		return candidates[len(candidates)-1:], nil
+	}
+
+	if n, err := strconv.Atoi(predicate); nil == err {
+		if (n < 1) || (n > len(candidates)) {
+			return nil, nil
+		}
+		return candidates[n-1 : n], nil
+	}
+
+	name, value, hasValue := predicate, "", false
+	if eq := strings.IndexByte(predicate, '='); eq >= 0 {
+		name, value = strings.TrimSpace(predicate[:eq]), strings.TrimSpace(predicate[eq+1:])
+		if (len(value) < 2) || ((value[0] != '\'') && (value[0] != '"')) || (value[len(value)-1] != value[0]) {
+			return nil, errors.New("Unsupported predicate:" + predicate)
+		}
+		value, hasValue = value[1:len(value)-1], true
+	}
+
+	if !validXPathName(strings.TrimPrefix(name, "@")) {
+		return nil, errors.New("Unsupported predicate:" + predicate)
+	}
+
+	var result []XMLNode
+	for _, node := range candidates {
+		elem := node.ToElement()
+		if nil == elem {
+			continue
+		}
+
+		if strings.HasPrefix(name, "@") {
+			if actual, ok := elem.LookupAttribute(name[1:]); ok && (!hasValue || (actual == value)) {
+				result = append(result, node)
+			}
+			continue
+		}
+
+		for child := elem.FirstChild(); nil != child; child = child.Next() {
+			if matchNodeTest(child, name) && (!hasValue || (stringValue(child) == value)) {
+				result = append(result, node)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// selectAttributes 返回contexts中所有元素上名为name的属性,name为*时返回所有属性
+func selectAttributes(contexts []XMLNode, name string) []XMLAttribute {
+	attrs := make([]XMLAttribute, 0)
+	for _, node := range contexts {
+		if nil == node {
+			continue
+		}
+
+		elem := node.ToElement()
+		if nil == elem {
+			continue
+		}
+
+		if "*" == name {
+			attrs = append(attrs, elem.Attributes()...)
+		} else if attr := elem.FindAttribute(name); nil != attr {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+// stringValue 返回节点的字符串值:元素和文档是其中所有文本拼接起来的结果,处理指令是指令的内容,其他节点是它们的值
+func stringValue(node XMLNode) string {
+	switch node.Type() {
+	case ElementNode, DocumentNode:
+		return innerText(node)
+	case ProcInstNode:
+		return node.ToProcInst().Instruction()
+	}
+
+	return node.Value()
+}
+
+// PruneOptions 选项,用于PruneEmptyWithOptions函数
+type PruneOptions struct {
+	KeepAttributed bool // 保留带有属性的空元素,这样的元素往往通过属性表达了含义,如<flag enabled="true"/>
+}
+
+// PruneEmpty 按照缺省选项删除root的子孙节点中所有的空元素,参见PruneEmptyWithOptions
+func PruneEmpty(root XMLNode) int {
+	return PruneEmptyWithOptions(root, PruneOptions{})
+}
+
+// PruneEmptyWithOptions 递归地删除root的子孙节点中所有的空元素(参见XMLElement.IsEmpty),返回删除的元素个数.
+// 先处理子节点再判断父节点,所以子元素都被删除之后变成空元素的父元素也会被删除.root自身不会被删除
+func PruneEmptyWithOptions(root XMLNode, options PruneOptions) int {
+	if nil == root {
+		return 0
+	}
+
+	count := 0
+	for child := root.FirstChild(); nil != child; {
+		next := child.Next()
+		if elem := child.ToElement(); nil != elem {
+			count += PruneEmptyWithOptions(elem, options)
+			if elem.IsEmpty() && !(options.KeepAttributed && (elem.AttributeCount() > 0)) {
+				root.DeleteChild(elem)
+				count++
+			}
+		}
+		child = next
+	}
+
+	return count
+}
+
+// FindAll 按照文档顺序遍历root及其所有子孙节点,返回所有满足pred条件的元素,如果root本身是元素也会参与匹配
+func FindAll(root XMLNode, pred func(XMLElement) bool) []XMLElement {
+	var result []XMLElement
+	if nil == root {
+		return result
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			if pred(elem) {
+				result = append(result, elem)
+			}
+			return true
+		},
+	})
+
+	return result
+}
+
+// FindFirst 按照文档顺序遍历root及其所有子孙节点,返回第一个满足pred条件的元素,找不到时返回nil
+func FindFirst(root XMLNode, pred func(XMLElement) bool) XMLElement {
+	var result XMLElement
+	if nil == root {
+		return result
+	}
+
+	// 找到之后所有的回调都返回false,使得遍历尽快结束
+	found := func() bool {
+		return nil == result
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			if (nil == result) && pred(elem) {
+				result = elem
+			}
+			return found()
+		},
+		ExitElement: func(XMLElement) bool { return found() },
+		ProcInst:    func(XMLProcInst) bool { return found() },
+		Text:        func(XMLText) bool { return found() },
+		Comment:     func(XMLComment) bool { return found() },
+		Directive:   func(XMLDirective) bool { return found() },
+	})
+
+	return result
+}
+
+// MaxDepth 通过一次遍历计算root子树中元素的最大嵌套层数,单个元素的层数为1,没有任何元素时返回0
+func MaxDepth(root XMLNode) int {
+	if nil == root {
+		return 0
+	}
+
+	depth, maxDepth := 0, 0
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(XMLElement) bool {
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+			return true
+		},
+		ExitElement: func(XMLElement) bool {
+			depth--
+			return true
+		},
+	})
+
+	return maxDepth
+}
+
+// TagCounts 通过一次遍历统计root子树中每种元素名出现的次数,如果root本身是元素也会被统计
+func TagCounts(root XMLNode) map[string]int {
+	counts := make(map[string]int)
+	if nil == root {
+		return counts
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			counts[elem.Name()]++
+			return true
+		},
+	})
+
+	return counts
+}
+
+// AttributeCounts 通过一次遍历统计root子树中所有元素上每种属性名出现的次数
+func AttributeCounts(root XMLNode) map[string]int {
+	counts := make(map[string]int)
+	if nil == root {
+		return counts
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			elem.ForeachAttribute(func(attr XMLAttribute) int {
+				counts[attr.Name()]++
+				return 0
+			})
+			return true
+		},
+	})
+
+	return counts
+}
+
+// RenameElements 将root及其子孙元素中所有名为oldName的元素改名为newName,返回被改名的元素个数.
+// oldName按照nameMatches的规则匹配,不带前缀时也会改名带前缀的同名元素,newName是改名之后完整的名字
+func RenameElements(root XMLNode, oldName string, newName string) int {
+	return RenameElementsFunc(root, func(elem XMLElement) bool {
+		return nameMatches(elem.Name(), oldName)
+	}, newName)
+}
+
+// RenameElementsFunc 将root及其子孙元素中所有满足pred条件的元素改名为newName,返回被改名的元素个数
+func RenameElementsFunc(root XMLNode, pred func(XMLElement) bool, newName string) int {
+	elems := FindAll(root, pred)
+	for _, elem := range elems {
+		elem.SetName(newName)
+	}
+
+	return len(elems)
+}
+
+// FindElementsByText 返回root及其子孙元素中文本内容包含substr的元素,按照文档顺序返回.
+// deep为false时只检查元素的直接Text子节点,为true时检查元素内所有子孙Text节点拼接起来的文本
+func FindElementsByText(root XMLNode, substr string, deep bool) []XMLElement {
+	return FindElementsByTextFunc(root, func(text string) bool {
+		return strings.Contains(text, substr)
+	}, deep)
+}
+
+// FindElementsByTextFunc 与FindElementsByText类似,只是由match来判断元素的文本是否满足要求
+func FindElementsByTextFunc(root XMLNode, match func(text string) bool, deep bool) []XMLElement {
+	return FindAll(root, func(elem XMLElement) bool {
+		if deep {
+			return match(innerText(elem))
+		}
+
+		return match(directText(elem))
+	})
+}
+
+// directText 返回node的所有直接Text子节点拼接起来的文本
+func directText(node XMLNode) string {
+	buf := bytes.NewBufferString("")
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		if nil != child.ToText() {
+			buf.WriteString(child.Value())
+		}
+	}
+
+	return buf.String()
+}
+
+// innerText 返回node的所有子孙Text节点按文档顺序拼接起来的文本
+func innerText(node XMLNode) string {
+	buf := bytes.NewBufferString("")
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		if nil != child.ToText() {
+			buf.WriteString(child.Value())
+		} else {
+			buf.WriteString(innerText(child))
+		}
+	}
+
+	return buf.String()
+}
+
+// StripNamespaces 删除root子树中所有的名字空间声明(xmlns、xmlns:prefix属性),并将带前缀的元素名、属性名都改成本地名,
+// 用于对接无法处理名字空间的工具.xml前缀是XML规范预先绑定的,xml:lang这样的属性保持不变.
+//
+// 去掉前缀之后同一个元素上可能出现同名的属性(如a:id和id),这时这些带前缀的属性保持原样,
+// 返回值就是这些冲突属性的路径,形如/root/item/@a:id,没有冲突时返回nil
+func StripNamespaces(root XMLNode) []string {
+	var conflicts []string
+	if nil == root {
+		return conflicts
+	}
+
+	root.Accept(&DefaultVisitor{
+		EnterElement: func(elem XMLElement) bool {
+			_, local := splitQualifiedName(elem.Name())
+			elem.SetName(local)
+
+			type attribute struct {
+				name  string
+				value string
+			}
+
+			// 先统计去掉前缀之后每个本地名出现的次数
+			var attrs []attribute
+			counts := make(map[string]int)
+			elem.ForeachAttribute(func(attr XMLAttribute) int {
+				prefix, local := splitQualifiedName(attr.Name())
+				if ("xmlns" == attr.Name()) || ("xmlns" == prefix) {
+					return 0
+				}
+
+				attrs = append(attrs, attribute{attr.Name(), attr.Value()})
+				if "xml" != prefix {
+					counts[local]++
+				}
+				return 0
+			})
+
+			elem.ClearAttributes()
+			for _, attr := range attrs {
+				prefix, local := splitQualifiedName(attr.name)
+				switch {
+				case ("" == prefix) || ("xml" == prefix):
+				case counts[local] > 1:
+					conflicts = append(conflicts, elem.Path()+"/@"+attr.name)
+				default:
+					attr.name = local
+				}
+				setAttributeExact(elem, attr.name, attr.value)
+			}
+			return true
+		},
+	})
+
+	return conflicts
+}
+
+// ChangeKind 表示Diff中一处变化的类型
+type ChangeKind int
+
+const (
+	ChangeAdded    ChangeKind = iota + 1 // 新增的节点或者属性
+	ChangeRemoved                        // 删除的节点或者属性
+	ChangeModified                       // 值被修改的节点或者属性
+)
+
+// Change 描述Diff得到的一处变化
+type Change struct {
+	Kind     ChangeKind
+	Path     string // 变化所在的位置,新增的节点使用它在b中的Path,其他的使用在a中的Path,属性的路径形如/root/item/@id
+	OldValue string // 删除或者修改之前的值,节点是它输出成字符串之后的内容,属性是属性值
+	NewValue string // 新增或者修改之后的值
+}
+
+// Diff 逐个节点比较a、b两棵子树,返回从a变成b的所有变化.
+//
+// 比较时按照名字和位置来配对:a中第i个名为item的子元素与b中第i个名为item的子元素配对,
+// 文本、注释等其他节点同样按照类型和位置配对.配对成功的元素比较属性并递归比较子节点,
+// 其他节点比较值是否相同;没有配对的节点视为删除或者新增.得到的不一定是最小的变化列表
+func Diff(a XMLNode, b XMLNode) []Change {
+	var changes []Change
+	switch {
+	case (nil == a) && (nil == b):
+	case nil == a:
+		changes = append(changes, Change{Kind: ChangeAdded, Path: b.Path(), NewValue: NodeToString(b, PrintStream)})
+	case nil == b:
+		changes = append(changes, Change{Kind: ChangeRemoved, Path: a.Path(), OldValue: NodeToString(a, PrintStream)})
+	case pathKind(a) != pathKind(b):
+		changes = append(changes, Change{Kind: ChangeRemoved, Path: a.Path(), OldValue: NodeToString(a, PrintStream)})
+		changes = append(changes, Change{Kind: ChangeAdded, Path: b.Path(), NewValue: NodeToString(b, PrintStream)})
+	default:
+		changes = diffNode(a, b, changes)
+	}
+
+	return changes
+}
+
+// diffNode 比较已经配对的两个同类节点,将变化追加到changes中
+func diffNode(a XMLNode, b XMLNode, changes []Change) []Change {
+	if (nil == a.ToElement()) && (nil == a.ToDocument()) {
+		if NodeToString(a, PrintStream) != NodeToString(b, PrintStream) {
+			changes = append(changes, Change{Kind: ChangeModified, Path: a.Path(),
+				OldValue: NodeToString(a, PrintStream), NewValue: NodeToString(b, PrintStream)})
+		}
+		return changes
+	}
+
+	if elemA, elemB := a.ToElement(), b.ToElement(); nil != elemA {
+		for _, name := range elemA.AttributeNames() {
+			// 这里要区分id和a:id,所以按照完整的名字查找
+			valueA, attrB := attributeExact(elemA, name).Value(), attributeExact(elemB, name)
+			switch {
+			case nil == attrB:
+				changes = append(changes, Change{Kind: ChangeRemoved, Path: a.Path() + "/@" + name, OldValue: valueA})
+			case attrB.Value() != valueA:
+				changes = append(changes, Change{Kind: ChangeModified, Path: a.Path() + "/@" + name,
+					OldValue: valueA, NewValue: attrB.Value()})
+			}
+		}
+
+		for _, name := range elemB.AttributeNames() {
+			if nil == attributeExact(elemA, name) {
+				changes = append(changes, Change{Kind: ChangeAdded, Path: b.Path() + "/@" + name, NewValue: attributeExact(elemB, name).Value()})
+			}
+		}
+	}
+
+	// 按照类型(元素按名字)和出现的次序配对子节点
+	childrenB := make(map[string][]XMLNode)
+	for child := b.FirstChild(); nil != child; child = child.Next() {
+		childrenB[pathKind(child)] = append(childrenB[pathKind(child)], child)
+	}
+
+	seen := make(map[string]int)
+	for child := a.FirstChild(); nil != child; child = child.Next() {
+		kind := pathKind(child)
+		index := seen[kind]
+		seen[kind]++
+		if index < len(childrenB[kind]) {
+			changes = diffNode(child, childrenB[kind][index], changes)
+		} else {
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: child.Path(), OldValue: NodeToString(child, PrintStream)})
+		}
+	}
+
+	for child := b.FirstChild(); nil != child; child = child.Next() {
+		kind := pathKind(child)
+		if seen[kind] > 0 {
+			seen[kind]--
+			continue
+		}
+		changes = append(changes, Change{Kind: ChangeAdded, Path: child.Path(), NewValue: NodeToString(child, PrintStream)})
+	}
+
+	return changes
+}
+
+// CompareOptions 比较选项,用于DeepEqualWithOptions函数,用于控制两棵子树的比较规则
+type CompareOptions struct {
+	AttributeOrder   bool // 属性的顺序是否参与比较,缺省情况下只要属性的名字和值的集合相同就认为相等
+	IgnoreWhitespace bool // 忽略空白:全空白的Text节点不参与比较,其余Text节点先去掉首尾空白并将连续的空白合并成一个空格再比较
+}
+
+// DeepEqual 按照缺省的比较选项判断a、b两棵子树的结构和内容是否相同,参见DeepEqualWithOptions
+func DeepEqual(a XMLNode, b XMLNode) bool {
+	return DeepEqualWithOptions(a, b, CompareOptions{})
+}
+
+// DeepEqualWithOptions 判断a、b两棵子树的结构和内容是否相同.
+// 参与比较的有:节点类型、元素名、属性、Text的内容和CDATA标记、注释、处理指令和指令的内容,以及递归比较所有子节点.
+// 节点所属的文档以及父节点不参与比较
+func DeepEqualWithOptions(a XMLNode, b XMLNode, options CompareOptions) bool {
+	if (nil == a) || (nil == b) {
+		return (nil == a) && (nil == b)
+	}
+
+	if !nodeEqual(a, b, options) {
+		return false
+	}
+
+	childA := a.FirstChild()
+	childB := b.FirstChild()
+	for {
+		childA = skipIgnorable(childA, options)
+		childB = skipIgnorable(childB, options)
+		if (nil == childA) || (nil == childB) {
+			return (nil == childA) && (nil == childB)
+		}
+
+		if !DeepEqualWithOptions(childA, childB, options) {
+			return false
+		}
+
+		childA = childA.Next()
+		childB = childB.Next()
+	}
+}
+
+// skipIgnorable 跳过比较时需要忽略的节点,返回node及其之后第一个需要参与比较的节点
+func skipIgnorable(node XMLNode, options CompareOptions) XMLNode {
+	for ; nil != node; node = node.Next() {
+		if !options.IgnoreWhitespace || (nil == node.ToText()) || ("" != strings.TrimSpace(node.Value())) {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func nodeEqual(a XMLNode, b XMLNode, options CompareOptions) bool {
+	if elemA, elemB := a.ToElement(), b.ToElement(); (nil != elemA) || (nil != elemB) {
+		return (nil != elemA) && (nil != elemB) && (elemA.Name() == elemB.Name()) && attributesEqual(elemA, elemB, options)
+	}
+
+	if textA, textB := a.ToText(), b.ToText(); (nil != textA) || (nil != textB) {
+		if (nil == textA) || (nil == textB) || (textA.CDATA() != textB.CDATA()) || (textA.Raw() != textB.Raw()) {
+			return false
+		}
+
+		if options.IgnoreWhitespace {
+			return collapseWhitespace(textA.Value()) == collapseWhitespace(textB.Value())
+		}
+
+		return textA.Value() == textB.Value()
+	}
+
+	if procA, procB := a.ToProcInst(), b.ToProcInst(); (nil != procA) || (nil != procB) {
+		return (nil != procA) && (nil != procB) && (procA.Target() == procB.Target()) && (procA.Instruction() == procB.Instruction())
+	}
+
+	if (nil != a.ToComment()) && (nil != b.ToComment()) {
+		return a.Value() == b.Value()
+	}
+
+	if (nil != a.ToDirective()) && (nil != b.ToDirective()) {
+		return a.Value() == b.Value()
+	}
+
+	return (nil != a.ToDocument()) && (nil != b.ToDocument())
+}
+
+func attributesEqual(a XMLElement, b XMLElement, options CompareOptions) bool {
+	if a.AttributeCount() != b.AttributeCount() {
+		return false
+	}
+
+	if !options.AttributeOrder {
+		return 0 == a.ForeachAttribute(func(attr XMLAttribute) int {
+			if other := attributeExact(b, attr.Name()); (nil != other) && (other.Value() == attr.Value()) {
+				return 0
+			}
+			return 1
+		})
+	}
+
+	elemA := a.(*xmlElementImpl).attrlist.Front()
+	elemB := b.(*xmlElementImpl).attrlist.Front()
+	for ; nil != elemA; elemA, elemB = elemA.Next(), elemB.Next() {
+		attrA := elemA.Value.(*xmlAttributeImpl)
+		attrB := elemB.Value.(*xmlAttributeImpl)
+		if (attrA.name != attrB.name) || (attrA.value != attrB.value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Hash 计算node子树的64位哈希值(FNV-1a),DeepEqual(a, b)为true的两棵子树的哈希值一定相同,可以用于缓存、去重时建立索引.
+//
+// 参与计算的内容与DeepEqual相同:节点类型、元素名、属性(按属性名排序,与属性的顺序无关)、Text的内容以及CDATA、Raw标记、
+// 注释、处理指令和指令的内容,以及按顺序递归计算的所有子节点;节点所属的文档、父节点以及在输入流中的位置不参与计算.
+// 结果只依赖于子树的内容,在不同的进程之间也保持稳定.node为nil时返回0
+func Hash(node XMLNode) uint64 {
+	if nil == node {
+		return 0
+	}
+
+	h := fnv.New64a()
+	hashNode(h, node)
+	return h.Sum64()
+}
+
+// hashNode 将node子树的内容写入h,每个字符串之前都写入它的长度,避免不同的内容拼接之后得到相同的字节序列
+func hashNode(h hash.Hash64, node XMLNode) {
+	write := func(s string) {
+		io.WriteString(h, strconv.Itoa(len(s))+":"+s)
+	}
+
+	h.Write([]byte{byte(node.Type())})
+	switch node.Type() {
+	case ElementNode:
+		elem := node.ToElement()
+		write(elem.Name())
+
+		names := elem.AttributeNames()
+		sort.Strings(names)
+		write(strconv.Itoa(len(names)))
+		for _, name := range names {
+			write(name)
+			write(attributeExact(elem, name).Value())
+		}
+	case TextNode:
+		text := node.ToText()
+		flags := byte(0)
+		if text.CDATA() {
+			flags |= 1
+		}
+		if text.Raw() {
+			flags |= 2
+		}
+		h.Write([]byte{flags})
+		write(text.Value())
+	case ProcInstNode:
+		write(node.ToProcInst().Target())
+		write(node.ToProcInst().Instruction())
+	case CommentNode, DirectiveNode:
+		write(node.Value())
+	}
+
+	// 子节点的个数也参与计算,使得<a><b/></a><c/>和<a><b/><c/></a>这样的结构可以区分开
+	write(strconv.Itoa(node.CountChildren()))
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		hashNode(h, child)
+	}
+}
+
+// collapseWhitespace 去掉首尾的空白,并将中间连续的空白合并成一个空格
+func collapseWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ------------------------------------------------------------------
+type xmlSimplePrinter struct {
+	writer      io.Writer    // 输出目的地
+	options     PrintOptions // 格式化选项
+	level       int          // 用于缩进时指定缩进级别
+	firstPrint  bool         // 是否首次输出
+	indentBytes []byte       // 索引字符流
+	lineHold    int          // 暂停折行的层数,含有文本的元素内部不折行也不缩进
+	err         error        // 输出过程中遇到的第一个错误,出错之后停止遍历
+
+	topLevelStarted bool // 已经输出过根元素的子节点,用于BlankLineBetweenTopLevel
+	afterComment    bool // 上一个输出的根元素子节点是注释,用于BlankLineBetweenTopLevel
+}
+
+// PrintOptions    打印选项,用于NewSimplePrinter函数,用于控制输出的XML内容的样式
+//
+// Indent字段有三种状态,需要特别注意nil和长度为0的区别:
+//   - Indent为nil: 不折行也不缩进,所有节点输出在同一行,如PrintStream
+//   - Indent长度为0(如[]byte{}): 每个节点单独一行,但是不缩进
+//   - Indent长度大于0: 每个节点单独一行,每一级缩进输出一次Indent,如PrintPretty
+//
+// 折行时,含有文本子节点的元素(如<name>John</name>或者混合内容)以及设置了xml:space="preserve"的元素整个输出在同一行,
+// 内部不折行也不缩进,以免插入的空白改变文本的内容.
+//
+// 建议使用IndentSpaces、IndentTabs来构造需要缩进的打印选项,而不是直接设置Indent.
+type PrintOptions struct {
+	Indent              []byte // 缩进前缀,只允许填写tab或者空白,如果Indent长度为0表示折行但是不缩进,如果Indent为null表示不折行
+	TextWrapWidth       int    // 超过多长才强制换行
+	NormalizeAttributes bool   // 输出时对属性值进行规范化,将\t、\n、\r替换为空格,参见normalizeAttributeValue
+	LineEnding          []byte // 折行时使用的换行符,为空时使用\n,Windows风格的换行可以指定为\r\n
+	WriteBOM            bool   // 在所有内容(包括XML声明)之前输出UTF-8的BOM头(EF BB BF),某些Windows下的工具需要它来识别编码
+
+	// Newline、IndentUnit是Indent三种状态的显式写法,二者的组合没有歧义:Newline为false时所有内容输出在同一行;
+	// Newline为true时折行,每级缩进一个IndentUnit,IndentUnit为空时折行但不缩进.
+	// Newline为true时忽略Indent;为false时仍然按照Indent的规则输出,以兼容原有的用法
+	Newline    bool
+	IndentUnit []byte
+
+	// AttrPerLine 折行时,有多个属性的元素每个属性单独占一行,比元素多缩进一级,>或者/>紧跟在最后一个属性之后,
+	// 类似Android的布局文件,便于在diff中查看属性的变化.只有一个属性的元素以及在同一行输出的含有文本的元素不受影响.
+	// 设置之后优先于TextWrapWidth.NewStreamWriter不支持这个选项
+	AttrPerLine bool
+
+	// BlankLineBetweenTopLevel 折行时在根元素的相邻子节点之间多输出一个空行,便于阅读手工编辑的配置文件.
+	// 注释之后不插入空行,使注释与它说明的下一个节点保持在一起.加载时这些空行不会被保留,
+	// 需要每次输出时设置这个选项.NewStreamWriter不支持这个选项
+	BlankLineBetweenTopLevel bool
+
+	NoSelfClose     bool            // 没有子节点的元素输出成<tag></tag>的形式,缺省输出成<tag/>的形式
+	SelfCloseExcept map[string]bool // 不遵循NoSelfClose规则的元素名,如NoSelfClose为true时仍然希望<br/>自闭合
+
+	// PreserveSelfClose 从输入流中加载的空元素保持原来的形式输出:原来是<a/>的仍然输出<a/>,原来是<a></a>的仍然输出<a></a>,
+	// 便于减少与手工编辑的文件之间的差异.其他元素(如程序创建的元素)仍然按照NoSelfClose、SelfCloseExcept的规则输出
+	PreserveSelfClose bool
+
+	Escaper          func(w io.Writer, s []byte) error // 文本转义函数,为nil时使用EscapeText
+	AttributeEscaper func(w io.Writer, s []byte) error // 属性值转义函数,为nil时使用EscapeAttribute
+
+	SkipComments   bool // 不输出注释,常用于对外发布文档之前去掉其中的内部备注
+	SkipDirectives bool // 不输出指令(如DOCTYPE)
+
+	// TrimText 输出文本时去掉首尾的空白,不修改DOM树中的内容.CDATA原样输出,不受影响
+	TrimText bool
+
+	// SanitizeComments 输出注释时在--中间以及结尾的-之后插入空格(如a--b输出成a- -b),保证输出的文档合法,
+	// 适用于注释内容来自用户输入的场景.缺省原样输出
+	SanitizeComments bool
+
+	// StrictChars 文本和属性值中含有XML 1.0无法表示的字符(如NUL)时停止输出,并由SaveDocument等函数返回错误.
+	// 缺省会将这样的字符替换成U+FFFD,输出的文档仍然合法但是数据已经被破坏了
+	StrictChars bool
+
+	// OnInvalidChar 文本和属性值中每个即将被替换成U+FFFD的字符(非法的UTF-8编码以utf8.RuneError给出)都会先交给它处理,
+	// 可以用来记录日志或者统计数据质量问题:返回nil时仍然替换成U+FFFD并继续输出,返回错误时停止输出,
+	// 并由SaveDocument等函数返回这个错误.需要自定义替换内容时请使用Escaper、AttributeEscaper,这时不会调用OnInvalidChar
+	OnInvalidChar func(r rune) error
+
+	// XML11 按照XML 1.1的字符范围输出文本和属性值:除NUL之外的控制字符都以&#xNN;的形式输出,而不是替换成U+FFFD.
+	// 这时应该自行在XML声明中使用version="1.1",另外设置了Escaper、AttributeEscaper时以它们为准
+	XML11 bool
+
+	// AttributeQuote 属性值使用的引号,只能是'"'或者'\'',为0时使用双引号.值中与引号相同的字符会被转义成&quot;或者&apos;,
+	// 另一种引号原样输出.设置了AttributeEscaper时需要由它自己负责转义对应的引号
+	AttributeQuote byte
+
+	// EscapeGreaterThan 文本中的>也转义成&gt;.缺省只转义&和<,这已经足够保证文档合法,
+	// 但是某些严格的解析器要求转义>,转义之后文本中也不会意外出现]]>.设置了Escaper时以Escaper为准
+	EscapeGreaterThan bool
+
+	// 下面这些函数用于给输出的各个部分加上颜色或者其他标记,如用ANSI转义序列包裹之后在终端中高亮显示.
+	// 参数是即将输出的一段内容(已经转义),返回值会代替它被输出,为nil时原样输出
+	TagColor       func([]byte) []byte // 标签部分,如<name、>、/>、</name>
+	AttrNameColor  func([]byte) []byte // 属性名
+	AttrValueColor func([]byte) []byte // 属性值,包括两边的引号
+	TextColor      func([]byte) []byte // 文本,CDATA包括<![CDATA[和]]>
+
+	// Canonical 按照Canonical XML 1.0(C14N)的规则输出,常用于XML数字签名,参见xmlCanonicalPrinter.
+	// 设置之后除SkipComments之外的其他选项都会被忽略
+	Canonical bool
+}
+
+var (
+	// PrintPretty  预制的打印选项,采用4个空格缩进
+	PrintPretty = PrintOptions{Indent: []byte("    "), TextWrapWidth: 200}
+
+	// PrintStream 流式打印选项,不缩进,不换行,节省流量
+	PrintStream = PrintOptions{}
+)
+
+// IndentSpaces 返回一个每级缩进n个空格的打印选项,n小于等于0时折行但不缩进
+func IndentSpaces(n int) PrintOptions {
+	return indentWith(' ', n)
+}
+
+// IndentTabs 返回一个每级缩进n个tab的打印选项,n小于等于0时折行但不缩进
+func IndentTabs(n int) PrintOptions {
+	return indentWith('\t', n)
+}
+
+func indentWith(c byte, n int) PrintOptions {
+	if n < 0 {
+		n = 0
+	}
+
+	// 这里总是返回非nil的Indent,保证一定会折行
+	return PrintOptions{Indent: bytes.Repeat([]byte{c}, n), TextWrapWidth: PrintPretty.TextWrapWidth}
+}
+
+// NewSimplePrinter 创建一个简单XML文档输出函数
+func NewSimplePrinter(writer io.Writer, options PrintOptions) XMLVisitor {
+	if options.Canonical {
+		return newCanonicalPrinter(writer, options)
+	}
+
+	visitor := new(xmlSimplePrinter)
+	visitor.writer = writer
+	visitor.options = layoutOptions(options)
+	visitor.level = 0
+	visitor.firstPrint = true
+	return visitor
+}
+
+// layoutOptions 将Newline、IndentUnit换算成打印器内部使用的Indent
+func layoutOptions(options PrintOptions) PrintOptions {
+	if options.Newline {
+		options.Indent = options.IndentUnit
+		if nil == options.Indent {
+			options.Indent = []byte{}
+		}
+	}
+
+	return options
+}
+
+func (p *xmlSimplePrinter) lineEnding() []byte {
+	if 0 == len(p.options.LineEnding) {
+		return []byte("\n")
+	}
+
+	return p.options.LineEnding
+}
+
+func (p *xmlSimplePrinter) indentSpace() {
+	first := p.firstPrint
+	p.firstPrint = false
+	if first && p.options.WriteBOM {
+		p.writer.Write(utf8BOM)
+	}
+
+	// Indent为nil时不折行也不缩进.在含有文本的元素内部插入换行和缩进会改变文本的内容,所以也不折行不缩进
+	if (nil == p.options.Indent) || (p.lineHold > 0) {
+		return
+	}
+
+	if !first {
+		p.writer.Write(p.lineEnding())
+	}
+
+	for i := 0; i < p.level; i++ {
+		p.writer.Write(p.options.Indent)
+	}
+}
+
+// topLevelSpace 在根元素的子节点开始输出之前调用,实现BlankLineBetweenTopLevel.空行总是跟在上一行的末尾之后,
+// 所以这里只需要多输出一个换行符,接下来的indentSpace会输出正常的换行和缩进
+func (p *xmlSimplePrinter) topLevelSpace() {
+	if !p.options.BlankLineBetweenTopLevel || (1 != p.level) || (nil == p.options.Indent) || (p.lineHold > 0) {
+		return
+	}
+
+	if p.topLevelStarted && !p.afterComment {
+		p.writer.Write(p.lineEnding())
+	}
+	p.topLevelStarted = true
+	p.afterComment = false
+}
+
+// inlineContent 判断元素的内容是否需要在同一行中原样输出:含有文本子节点,或者设置了xml:space="preserve"
+func inlineContent(node XMLElement) bool {
+	return hasTextChild(node) || ("preserve" == node.Attribute("xml:space", ""))
+}
+
+// spacePreserved 判断node是否处于xml:space="preserve"的作用范围内.
+// xml:space由node自身以及祖先元素中最近的一个设置了它的元素决定,值为default时恢复缺省的空白处理方式
+func spacePreserved(node XMLNode) bool {
+	for ; nil != node; node = node.Parent() {
+		if elem := node.ToElement(); nil != elem {
+			if value, ok := elem.LookupAttribute("xml:space"); ok {
+				return "preserve" == value
+			}
+		}
+	}
+
+	return false
+}
+
+// hasTextChild 判断node是否含有文本子节点(包括CDATA),这样的元素的内容需要原样输出
+func hasTextChild(node XMLNode) bool {
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		if nil != child.ToText() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail 记录输出过程中的第一个错误,返回是否可以继续输出
+func (p *xmlSimplePrinter) fail(err error) bool {
+	if nil == p.err {
+		p.err = err
+	}
+
+	return nil == p.err
+}
+
+// printError 返回打印过程中遇到的错误,visitor不是NewSimplePrinter创建的时候总是返回nil
+func printError(visitor XMLVisitor) error {
+	if printer, ok := visitor.(*xmlSimplePrinter); ok {
+		return printer.err
+	}
+
+	return nil
+}
+
+func (p *xmlSimplePrinter) escapeText(s []byte) error {
+	if p.options.StrictChars {
+		if err := checkCharacters(s, p.options.XML11); nil != err {
+			return err
+		}
+	}
+
+	if nil != p.options.Escaper {
+		return p.options.Escaper(p.writer, s)
+	}
+
+	if err := p.reportInvalidChars(s); nil != err {
+		return err
+	}
+
+	if p.options.XML11 {
+		return escapeXML11(p.writer, s, 0, p.options.EscapeGreaterThan)
+	}
+
+	return escapeText(p.writer, s, p.options.EscapeGreaterThan)
+}
+
+func (p *xmlSimplePrinter) escapeAttribute(s []byte) error {
+	if p.options.StrictChars {
+		if err := checkCharacters(s, p.options.XML11); nil != err {
+			return err
+		}
+	}
+
+	if nil != p.options.AttributeEscaper {
+		return p.options.AttributeEscaper(p.writer, s)
+	}
+
+	if err := p.reportInvalidChars(s); nil != err {
+		return err
+	}
+
+	if p.options.XML11 {
+		return escapeXML11(p.writer, s, p.attributeQuote(), false)
+	}
+
+	return EscapeAttributeQuote(p.writer, s, p.attributeQuote())
+}
+
+// paint 将piece交给color处理之后输出,color为nil时直接输出
+func (p *xmlSimplePrinter) paint(color func([]byte) []byte, piece []byte) {
+	if nil != color {
+		piece = color(piece)
+	}
+
+	p.writer.Write(piece)
+}
+
+// paintWith 将output输出的内容先收集起来,交给color处理之后再输出,color为nil时output直接输出到writer
+func (p *xmlSimplePrinter) paintWith(color func([]byte) []byte, output func() error) error {
+	if nil == color {
+		return output()
+	}
+
+	writer := p.writer
+	buf := bytes.NewBufferString("")
+	p.writer = buf
+	err := output()
+	p.writer = writer
+
+	p.writer.Write(color(buf.Bytes()))
+	return err
+}
+
+// attrPerLine 判断node的每个属性是否需要单独占一行,参见PrintOptions.AttrPerLine
+func (p *xmlSimplePrinter) attrPerLine(node XMLElement) bool {
+	return p.options.AttrPerLine && (nil != p.options.Indent) && (0 == p.lineHold) && (node.AttributeCount() > 1)
+}
+
+// writeAttribute 输出一个属性,不包括前面的空白
+func (p *xmlSimplePrinter) writeAttribute(name string, value string) {
+	if p.options.NormalizeAttributes {
+		value = normalizeAttributeValue(value)
+	}
+
+	p.paint(p.options.AttrNameColor, []byte(name))
+	p.writer.Write([]byte("="))
+	p.fail(p.paintWith(p.options.AttrValueColor, func() error {
+		p.writer.Write([]byte{p.attributeQuote()})
+		err := p.escapeAttribute([]byte(value))
+		p.writer.Write([]byte{p.attributeQuote()})
+		return err
+	}))
+}
+
+// writeText 转义并输出文本
+func (p *xmlSimplePrinter) writeText(text string) bool {
+	return p.fail(p.paintWith(p.options.TextColor, func() error {
+		return p.escapeText([]byte(text))
+	}))
+}
+
+// reportInvalidChars 对s中每个即将被替换成U+FFFD的字符调用OnInvalidChar
+func (p *xmlSimplePrinter) reportInvalidChars(s []byte) error {
+	if nil == p.options.OnInvalidChar {
+		return nil
+	}
+
+	return foreachInvalidChar(s, p.options.XML11, func(r rune, offset int) error {
+		return p.options.OnInvalidChar(r)
+	})
+}
+
+// attributeQuote 返回属性值使用的引号,只支持单引号和双引号,缺省为双引号
+func (p *xmlSimplePrinter) attributeQuote() byte {
+	if '\'' == p.options.AttributeQuote {
+		return '\''
+	}
+
+	return '"'
+}
+
+func (p *xmlSimplePrinter) VisitEnterDocument(node XMLDocument) bool {
+	return true
+}
+
+func (p *xmlSimplePrinter) VisitExitDocument(node XMLDocument) bool {
+	return true
+}
+
+func (p *xmlSimplePrinter) VisitEnterElement(node XMLElement) bool {
+	p.topLevelSpace()
+	p.indentSpace()
+	if 0 == p.level {
+		p.topLevelStarted = false
+	}
+	p.level++
+
+	p.paint(p.options.TagColor, []byte("<"+node.Name()))
+
+	separator := []byte(" ")
+	if p.attrPerLine(node) {
+		separator = append(append([]byte{}, p.lineEnding()...), bytes.Repeat(p.options.Indent, p.level)...)
+	}
+
+	node.ForeachAttribute(func(attribute XMLAttribute) int {
+		p.writer.Write(separator)
+		p.writeAttribute(attribute.Name(), attribute.Value())
+		return 0
+	})
+
+	if node.NoChildren() && p.selfClose(node) {
+		p.level--
+		p.paint(p.options.TagColor, []byte("/>"))
+		return nil == p.err
+	}
+
+	p.paint(p.options.TagColor, []byte(">"))
+	if inlineContent(node) {
+		p.lineHold++
+	}
+	return nil == p.err
+}
+
+// skipped 判断节点是否因为SkipComments、SkipDirectives而不输出
+func (p *xmlSimplePrinter) skipped(node XMLNode) bool {
+	return (p.options.SkipComments && (nil != node.ToComment())) ||
+		(p.options.SkipDirectives && (nil != node.ToDirective()))
+}
+
+// noPrintedChildren 判断元素是否没有需要输出的子节点.子节点全部被跳过时</tag>紧跟在<tag>之后输出,
+// 避免出现<tag>和</tag>之间只有换行和缩进的情况.是否自闭合仍然只由元素本身有没有子节点决定
+func (p *xmlSimplePrinter) noPrintedChildren(node XMLElement) bool {
+	for child := node.FirstChild(); nil != child; child = child.Next() {
+		if !p.skipped(child) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selfClose 判断一个没有子节点的元素是否应该输出成<tag/>的形式
+func (p *xmlSimplePrinter) selfClose(node XMLElement) bool {
+	if impl, ok := node.(*xmlElementImpl); ok && p.options.PreserveSelfClose && (closeFormUnknown != impl.closeForm) {
+		return closeFormSelfClose == impl.closeForm
+	}
+
+	return p.options.NoSelfClose == p.options.SelfCloseExcept[node.Name()]
+}
+
+func (p *xmlSimplePrinter) VisitExitElement(node XMLElement) bool {
+	if node.NoChildren() && p.selfClose(node) {
+		return true
+	}
+
+	p.level--
+	if inlineContent(node) {
+		p.lineHold--
+	} else if !p.noPrintedChildren(node) {
+		p.indentSpace()
+	}
+	p.paint(p.options.TagColor, []byte("</"+node.Name()+">"))
+	return true
+}
+
+func (p *xmlSimplePrinter) VisitProcInst(node XMLProcInst) bool {
+	p.topLevelSpace()
+	p.indentSpace()
+	p.writer.Write([]byte("<?"))
+	p.writer.Write([]byte(node.Target()))
+	// 没有指令内容时输出成<?target?>,不需要多余的空格
+	if "" != node.Instruction() {
+		p.writer.Write([]byte(" "))
+		p.writer.Write([]byte(node.Instruction()))
+	}
+	p.writer.Write([]byte("?>"))
+	return true
+}
+
+func (p *xmlSimplePrinter) VisitText(node XMLText) bool {
+	p.indentSpace()
+	if node.CDATA() {
+		if p.options.StrictChars && !p.fail(checkCharacters([]byte(node.Value()), p.options.XML11)) {
+			return false
+		}
+
+		p.paint(p.options.TextColor, []byte("<![CDATA["+node.Value()+"]]>"))
+		return true
+	}
+
+	value := node.Value()
+	if p.options.TrimText {
+		value = strings.TrimSpace(value)
+	}
+
+	if node.Raw() {
+		p.paint(p.options.TextColor, []byte(value))
+		return true
+	}
+
+	return p.writeText(value)
+}
+
+func (p *xmlSimplePrinter) VisitComment(node XMLComment) bool {
+	if p.skipped(node) {
+		return true
+	}
+
+	p.topLevelSpace()
+	p.indentSpace()
+	p.afterComment = (1 == p.level)
+	p.writer.Write([]byte("<!--"))
+	p.writer.Write([]byte(p.comment(node.Value())))
+	p.writer.Write([]byte("-->"))
+	return true
+}
+
+// comment 返回需要输出的注释内容
+func (p *xmlSimplePrinter) comment(s string) string {
+	if p.options.SanitizeComments {
+		return sanitizeComment(s)
+	}
+
+	return s
+}
+
+func (p *xmlSimplePrinter) VisitDirective(node XMLDirective) bool {
+	if p.skipped(node) {
+		return true
+	}
+
+	p.topLevelSpace()
+	p.indentSpace()
+	p.writer.Write([]byte("<!"))
+	// 解析器给出的指令内容是未经反转义的原始内容(如DOCTYPE的内部子集),所以这里也需要原样输出
+	p.writer.Write([]byte(node.Value()))
+	p.writer.Write([]byte(">"))
+	return true
+}
+
+// XMLStreamWriter 不需要构造DOM树就可以直接输出XML,内存占用与文档大小无关,适合输出很大的报表之类的文档.
+//
+// 转义、缩进等输出格式与NewSimplePrinter一致,都由PrintOptions控制.由于是边写边输出,缩进模式下只能在
+// 元素中第一次出现文本之后才停止折行,之前的子元素仍然会折行缩进.
+//
+// 所有方法在出错之后都不再输出,并且总是返回第一次遇到的错误.结束时必须调用Close,
+// Close会依次结束所有还没有结束的元素,并将缓冲的内容写入到writer中.
+type XMLStreamWriter interface {
+	StartElement(name string) error
+	Attr(name string, value string) error
+	Text(s string) error
+	EndElement() error
+	Comment(s string) error
+	Close() error
+}
+
+// streamElement 记录XMLStreamWriter中尚未结束的元素
+type streamElement struct {
+	name        string
+	hasChildren bool // 是否已经输出过子节点
+	inline      bool // 出现文本之后,元素内部不再折行
+}
+
+type xmlStreamWriterImpl struct {
+	printer  *xmlSimplePrinter
+	buffered *bufio.Writer
+	stack    []*streamElement
+	tagOpen  bool // 开始标签还没有输出>,此时还可以添加属性
+}
+
+// NewStreamWriter 创建一个直接向writer输出XML的XMLStreamWriter
+func NewStreamWriter(writer io.Writer, options PrintOptions) XMLStreamWriter {
+	w := new(xmlStreamWriterImpl)
+	w.buffered = bufio.NewWriter(writer)
+	w.printer = &xmlSimplePrinter{writer: w.buffered, options: layoutOptions(options), firstPrint: true}
+	return w
+}
+
+// top 返回最内层尚未结束的元素,没有时返回nil
+func (w *xmlStreamWriterImpl) top() *streamElement {
+	if 0 == len(w.stack) {
+		return nil
+	}
+
+	return w.stack[len(w.stack)-1]
+}
+
+// beginChild 在输出子节点之前补上开始标签的>,并记录父元素已经有了子节点
+func (w *xmlStreamWriterImpl) beginChild() {
+	if w.tagOpen {
+		w.printer.paint(w.printer.options.TagColor, []byte(">"))
+		w.tagOpen = false
+	}
+
+	if top := w.top(); nil != top {
+		top.hasChildren = true
+	}
+}
+
+func (w *xmlStreamWriterImpl) StartElement(name string) error {
+	if nil != w.printer.err {
+		return w.printer.err
+	}
+
+	if !ValidName(name) {
+		w.printer.fail(errors.New("Invalid element name:" + name))
+		return w.printer.err
+	}
+
+	w.beginChild()
+	w.printer.indentSpace()
+	w.printer.level++
+	w.printer.paint(w.printer.options.TagColor, []byte("<"+name))
+	w.stack = append(w.stack, &streamElement{name: name})
+	w.tagOpen = true
+	return nil
+}
+
+func (w *xmlStreamWriterImpl) Attr(name string, value string) error {
+	if nil != w.printer.err {
+		return w.printer.err
+	}
+
+	if !w.tagOpen {
+		w.printer.fail(errors.New("Attribute must follow StartElement:" + name))
+		return w.printer.err
+	}
+
+	if !ValidName(name) {
+		w.printer.fail(errors.New("Invalid attribute name:" + name))
+		return w.printer.err
+	}
+
+	w.printer.writer.Write([]byte(" "))
+	w.printer.writeAttribute(name, value)
+
+	// 与输出DOM树时一样,xml:space="preserve"的元素的内容不折行也不缩进
+	if top := w.top(); ("xml:space" == name) && ("preserve" == value) && !top.inline {
+		top.inline = true
+		w.printer.lineHold++
+	}
+	return w.printer.err
+}
+
+func (w *xmlStreamWriterImpl) Text(s string) error {
+	if nil != w.printer.err {
+		return w.printer.err
+	}
+
+	w.beginChild()
+	if top := w.top(); (nil != top) && !top.inline {
+		top.inline = true
+		w.printer.lineHold++
+	}
+
+	w.printer.indentSpace()
+	w.printer.writeText(s)
+	return w.printer.err
 }
 
-// VisitExitElement is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitExitElement(elem XMLElement) bool {
-	if nil == v.ExitElement {
-		return true
+func (w *xmlStreamWriterImpl) Comment(s string) error {
+	if nil != w.printer.err {
+		return w.printer.err
 	}
 
-	return v.ExitElement(elem)
+	w.beginChild()
+	w.printer.indentSpace()
+	w.printer.writer.Write([]byte("<!--" + w.printer.comment(s) + "-->"))
+	return nil
 }
 
-// VisitProcInst is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitProcInst(pi XMLProcInst) bool {
-	if nil == v.ProcInst {
-		return true
+func (w *xmlStreamWriterImpl) EndElement() error {
+	if nil != w.printer.err {
+		return w.printer.err
 	}
 
-	return v.ProcInst(pi)
-}
+	top := w.top()
+	if nil == top {
+		w.printer.fail(errors.New("No element to end"))
+		return w.printer.err
+	}
 
-// VisitText is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitText(text XMLText) bool {
-	if nil == v.Text {
-		return true
+	w.stack = w.stack[:len(w.stack)-1]
+	w.printer.level--
+	if w.tagOpen && (w.printer.options.NoSelfClose == w.printer.options.SelfCloseExcept[top.name]) {
+		w.printer.paint(w.printer.options.TagColor, []byte("/>"))
+		w.tagOpen = false
+		return nil
 	}
 
-	return v.Text(text)
+	w.beginChild()
+	if top.inline {
+		w.printer.lineHold--
+	} else if top.hasChildren {
+		w.printer.indentSpace()
+	}
+
+	w.printer.paint(w.printer.options.TagColor, []byte("</"+top.name+">"))
+	return nil
 }
 
-// VisitComment is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitComment(c XMLComment) bool {
-	if nil == v.Comment {
-		return true
+func (w *xmlStreamWriterImpl) Close() error {
+	for (nil == w.printer.err) && (nil != w.top()) {
+		w.EndElement()
 	}
 
-	return v.Comment(c)
+	if nil != w.printer.err {
+		return w.printer.err
+	}
+
+	return w.buffered.Flush()
 }
 
-// VisitDirective is the default implement of XMLVisitor
-func (v *DefaultVisitor) VisitDirective(d XMLDirective) bool {
-	if nil == v.Directive {
-		return true
-	}
+// xmlCanonicalPrinter 按照Canonical XML 1.0(https://www.w3.org/TR/xml-c14n)的规则输出文档,主要规则如下:
+//   - 不输出XML声明和DOCTYPE等指令,没有子节点的元素输出成<tag></tag>的形式
+//   - 名字空间声明排在其他属性前面并按前缀排序,其他属性按照(名字空间URI,本地名)排序
+//   - 去掉与祖先元素重复的名字空间声明,输出子树时补上从祖先元素继承下来的名字空间声明
+//   - CDATA按普通文本转义输出,文本和属性值使用C14N规定的转义方式
+//   - 根元素之前和之后的注释、处理指令用\n分隔
+//
+// 缺省会输出注释,即C14N的WithComments版本,同时设置PrintOptions.SkipComments时得到不带注释的版本.
+// 由于加载文档时丢弃了用于缩进的空白文本节点(参见LoadDocument),也不记录DTD中的属性缺省值,对于依赖这些内容的文档,输出结果与标准的C14N会有差异
+type xmlCanonicalPrinter struct {
+	writer     io.Writer
+	options    PrintOptions
+	rootDone   bool                // 根元素是否已经输出完毕
+	namespaces []map[string]string // 每一层已经输出的名字空间声明,前缀 -> URI
+}
 
-	return v.Directive(d)
+var (
+	canonicalTextReplacer      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	canonicalAttributeReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func newCanonicalPrinter(writer io.Writer, options PrintOptions) XMLVisitor {
+	printer := new(xmlCanonicalPrinter)
+	printer.writer = writer
+	printer.options = options
+	return printer
 }
 
-// ------------------------------------------------------------------
-type xmlSimplePrinter struct {
-	writer      io.Writer    // 输出目的地
-	options     PrintOptions // 格式化选项
-	level       int          // 用于缩进时指定缩进级别
-	firstPrint  bool         // 是否首次输出
-	indentBytes []byte       // 索引字符流
-	lineHold    bool         // 暂停换行
+// topLevel 判断node是否是文档的直接子节点
+func topLevel(node XMLNode) bool {
+	return (nil != node.Parent()) && (nil != node.Parent().ToDocument())
 }
 
-// PrintOptions    打印选项,用于NewSimplePrinter函数,用于控制输出的XML内容的样式
-type PrintOptions struct {
-	Indent        []byte // 缩进前缀,只允许填写tab或者空白,如果Indent长度为0表示折行但是不缩进,如果Indent为null表示不折行
-	TextWrapWidth int    // 超过多长才强制换行
+// beforeTopLevel 根元素之外的节点,在根元素之后的需要以\n开头
+func (p *xmlCanonicalPrinter) beforeTopLevel(node XMLNode) {
+	if topLevel(node) && p.rootDone {
+		io.WriteString(p.writer, "\n")
+	}
 }
 
-var (
-	// PrintPretty  预制的打印选项,采用4个空格缩进
-	PrintPretty = PrintOptions{Indent: []byte("    "), TextWrapWidth: 200}
+// afterTopLevel 根元素之外的节点,在根元素之前的需要以\n结尾
+func (p *xmlCanonicalPrinter) afterTopLevel(node XMLNode) {
+	if topLevel(node) && !p.rootDone {
+		io.WriteString(p.writer, "\n")
+	}
+}
 
-	// PrintStream 流式打印选项,不缩进,不换行,节省流量
-	PrintStream = PrintOptions{}
-)
+func (p *xmlCanonicalPrinter) VisitEnterDocument(node XMLDocument) bool {
+	return true
+}
 
-// NewSimplePrinter 创建一个简单XML文档输出函数
-func NewSimplePrinter(writer io.Writer, options PrintOptions) XMLVisitor {
-	visitor := new(xmlSimplePrinter)
-	visitor.writer = writer
-	visitor.options = options
-	visitor.level = 0
-	visitor.firstPrint = true
-	return visitor
+func (p *xmlCanonicalPrinter) VisitExitDocument(node XMLDocument) bool {
+	return true
 }
 
-func (p *xmlSimplePrinter) indentSpace() {
-	if nil != p.options.Indent {
-		if len(p.options.Indent) >= 0 {
-			if !p.firstPrint {
-				p.writer.Write([]byte("\n"))
+// namespaceDeclarations 返回node需要输出的名字空间声明,输出的第一个元素还需要带上祖先元素中声明的名字空间
+func (p *xmlCanonicalPrinter) namespaceDeclarations(node XMLElement) map[string]string {
+	decls := make(map[string]string)
+	collect := func(elem XMLElement) {
+		elem.ForeachAttribute(func(attr XMLAttribute) int {
+			prefix, local := splitQualifiedName(attr.Name())
+			if "xmlns" == attr.Name() {
+				local = ""
+			} else if "xmlns" != prefix {
+				return 0
 			}
-		}
+
+			if _, ok := decls[local]; !ok {
+				decls[local] = attr.Value()
+			}
+			return 0
+		})
 	}
 
-	for i := 0; i < p.level; i++ {
-		p.writer.Write(p.options.Indent)
+	collect(node)
+	if 0 == len(p.namespaces) {
+		for parent := node.Parent(); nil != parent; parent = parent.Parent() {
+			if elem := parent.ToElement(); nil != elem {
+				collect(elem)
+			}
+		}
 	}
 
-	p.firstPrint = false
+	return decls
 }
 
-func (p *xmlSimplePrinter) VisitEnterDocument(node XMLDocument) bool {
-	return true
+// canonicalAttribute 规范化输出时的属性,按照名字空间URI、本地名排序
+type canonicalAttribute struct {
+	space string
+	local string
+	attr  XMLAttribute
 }
 
-func (p *xmlSimplePrinter) VisitExitDocument(node XMLDocument) bool {
-	return true
+// canonicalAttributes 实现了sort.Interface,按照C14N规定的顺序排列属性
+type canonicalAttributes []canonicalAttribute
+
+func (a canonicalAttributes) Len() int      { return len(a) }
+func (a canonicalAttributes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a canonicalAttributes) Less(i, j int) bool {
+	if a[i].space != a[j].space {
+		return a[i].space < a[j].space
+	}
+	return a[i].local < a[j].local
 }
 
-func (p *xmlSimplePrinter) VisitEnterElement(node XMLElement) bool {
-	p.indentSpace()
-	p.level++
+func (p *xmlCanonicalPrinter) VisitEnterElement(node XMLElement) bool {
+	inScope := map[string]string{}
+	if 0 != len(p.namespaces) {
+		inScope = p.namespaces[len(p.namespaces)-1]
+	}
+
+	// 去掉与已经输出的声明相同的名字空间声明,缺省名字空间没有声明时相当于声明为空串
+	rendered := make(map[string]string, len(inScope))
+	for prefix, uri := range inScope {
+		rendered[prefix] = uri
+	}
 
-	p.writer.Write([]byte("<"))
-	p.writer.Write([]byte(node.Name()))
+	var prefixes []string
+	for prefix, uri := range p.namespaceDeclarations(node) {
+		if current, ok := inScope[prefix]; (current == uri) && (ok || ("" == prefix)) {
+			continue
+		}
 
-	node.ForeachAttribute(func(attribute XMLAttribute) int {
-		p.writer.Write([]byte(` `))
-		p.writer.Write([]byte(attribute.Name()))
-		p.writer.Write([]byte(`="`))
-		EscapeAttribute(p.writer, []byte(attribute.Value()))
-		p.writer.Write([]byte(`"`))
+		rendered[prefix] = uri
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	p.namespaces = append(p.namespaces, rendered)
+
+	var attrs canonicalAttributes
+	node.ForeachAttribute(func(attr XMLAttribute) int {
+		prefix, local := splitQualifiedName(attr.Name())
+		if ("xmlns" == attr.Name()) || ("xmlns" == prefix) {
+			return 0
+		}
+
+		space := ""
+		if "" != prefix {
+			space = lookupNamespaceURI(node, prefix)
+		}
+
+		attrs = append(attrs, canonicalAttribute{space, local, attr})
 		return 0
 	})
+	sort.Stable(attrs)
 
-	if node.NoChildren() {
-		p.level--
-		p.writer.Write([]byte("/>"))
-		return true
+	io.WriteString(p.writer, "<"+node.Name())
+	for _, prefix := range prefixes {
+		name := "xmlns"
+		if "" != prefix {
+			name += ":" + prefix
+		}
+
+		io.WriteString(p.writer, " "+name+`="`)
+		canonicalAttributeReplacer.WriteString(p.writer, rendered[prefix])
+		io.WriteString(p.writer, `"`)
 	}
 
-	p.writer.Write([]byte(">"))
+	for _, attr := range attrs {
+		io.WriteString(p.writer, " "+attr.attr.Name()+`="`)
+		canonicalAttributeReplacer.WriteString(p.writer, attr.attr.Value())
+		io.WriteString(p.writer, `"`)
+	}
+
+	io.WriteString(p.writer, ">")
 	return true
 }
 
-func (p *xmlSimplePrinter) VisitExitElement(node XMLElement) bool {
-	if node.NoChildren() {
+func (p *xmlCanonicalPrinter) VisitExitElement(node XMLElement) bool {
+	io.WriteString(p.writer, "</"+node.Name()+">")
+	p.namespaces = p.namespaces[:len(p.namespaces)-1]
+	if topLevel(node) {
+		p.rootDone = true
+	}
+	return true
+}
+
+func (p *xmlCanonicalPrinter) VisitProcInst(node XMLProcInst) bool {
+	// XML声明不属于规范化的输出内容
+	if "xml" == node.Target() {
 		return true
 	}
 
-	p.level--
-	p.indentSpace()
-	p.writer.Write([]byte("</"))
-	p.writer.Write([]byte(node.Name()))
-	p.writer.Write([]byte(">"))
+	p.beforeTopLevel(node)
+	io.WriteString(p.writer, "<?"+node.Target())
+	if "" != node.Instruction() {
+		io.WriteString(p.writer, " "+node.Instruction())
+	}
+	io.WriteString(p.writer, "?>")
+	p.afterTopLevel(node)
 	return true
 }
 
-func (p *xmlSimplePrinter) VisitProcInst(node XMLProcInst) bool {
-	p.indentSpace()
-	p.writer.Write([]byte("<?"))
-	p.writer.Write([]byte(node.Target()))
-	p.writer.Write([]byte(" "))
-	p.writer.Write([]byte(node.Instruction()))
-	p.writer.Write([]byte("?>"))
+func (p *xmlCanonicalPrinter) VisitText(node XMLText) bool {
+	if node.Raw() && !node.CDATA() {
+		io.WriteString(p.writer, node.Value())
+		return true
+	}
+
+	canonicalTextReplacer.WriteString(p.writer, node.Value())
 	return true
 }
 
-func (p *xmlSimplePrinter) VisitText(node XMLText) bool {
-	p.indentSpace()
-	if node.CDATA() {
-		p.writer.Write([]byte("<![CDATA["))
-		p.writer.Write([]byte(node.Value()))
-		p.writer.Write([]byte("]]>"))
+func (p *xmlCanonicalPrinter) VisitComment(node XMLComment) bool {
+	if p.options.SkipComments {
 		return true
 	}
 
-	EscapeText(p.writer, []byte(node.Value()))
+	p.beforeTopLevel(node)
+	io.WriteString(p.writer, "<!--"+node.Value()+"-->")
+	p.afterTopLevel(node)
 	return true
 }
 
-func (p *xmlSimplePrinter) VisitComment(node XMLComment) bool {
-	p.indentSpace()
-	p.writer.Write([]byte("<!--"))
-	p.writer.Write([]byte(node.Value()))
-	p.writer.Write([]byte("-->"))
+func (p *xmlCanonicalPrinter) VisitDirective(node XMLDirective) bool {
 	return true
 }
 
-func (p *xmlSimplePrinter) VisitDirective(node XMLDirective) bool {
-	p.indentSpace()
-	p.writer.Write([]byte("<!"))
-	EscapeText(p.writer, []byte(node.Value()))
-	p.writer.Write([]byte(">"))
-	return true
+// normalizeAttributeValue 按照XML规范的属性值规范化规则,将属性值中的\t、\n、\r替换为空格,\r\n视为一个换行只替换成一个空格.
+//
+// 由于tinydom并不记录DTD中声明的属性类型,所以这里统一采用CDATA类型属性的规范化规则,
+// 即只做空白字符替换,不会合并连续的空格,也不会去除首尾的空格.
+func normalizeAttributeValue(value string) string {
+	buf := bytes.NewBufferString("")
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\r':
+			if (i+1 < len(value)) && ('\n' == value[i+1]) {
+				i++
+			}
+			buf.WriteByte(' ')
+		case '\t', '\n':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+
+	return buf.String()
 }
 
 // ------------------------------------------------------------------
@@ -1276,16 +5136,107 @@ func isInCharacterRange(r rune) (inrange bool) {
 	return r == 0x09 ||
 		r == 0x0A ||
 		r == 0x0D ||
-		r >= 0x20 && r <= 0xDF77 ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// isInCharacterRange11 判断r是否属于XML 1.1的Char,与XML 1.0相比允许除NUL之外的所有控制字符
+func isInCharacterRange11(r rune) bool {
+	return r >= 0x01 && r <= 0xD7FF ||
 		r >= 0xE000 && r <= 0xFFFD ||
 		r >= 0x10000 && r <= 0x10FFFF
 }
 
+// isRestrictedChar 判断r是否属于XML 1.1的RestrictedChar,这些字符只能以&#xNN;的形式出现在文档中
+func isRestrictedChar(r rune) bool {
+	return r >= 0x01 && r <= 0x08 ||
+		r >= 0x0B && r <= 0x0C ||
+		r >= 0x0E && r <= 0x1F ||
+		r >= 0x7F && r <= 0x84 ||
+		r >= 0x86 && r <= 0x9F
+}
+
+// ValidComment 判断s能否作为注释的内容:XML规定注释中不能出现--,也不能以-结尾
+func ValidComment(s string) bool {
+	return !strings.Contains(s, "--") && !strings.HasSuffix(s, "-")
+}
+
+// sanitizeComment 在--中间以及结尾的-之后插入空格,使s成为合法的注释内容,参见PrintOptions.SanitizeComments
+func sanitizeComment(s string) string {
+	for strings.Contains(s, "--") {
+		s = strings.Replace(s, "--", "- -", -1)
+	}
+
+	if strings.HasSuffix(s, "-") {
+		s += " "
+	}
+
+	return s
+}
+
+// ValidName 判断name是否符合XML 1.0规范中Name的定义:以字母、下划线、冒号或者其他NameStartChar开头,
+// 后面是NameChar(在NameStartChar的基础上增加了数字、-、.等),不能为空也不能含有空白
+func ValidName(name string) bool {
+	if "" == name {
+		return false
+	}
+
+	for i := 0; i < len(name); {
+		r, width := utf8.DecodeRuneInString(name[i:])
+		// 非法的UTF-8编码解码成宽度为1的RuneError,字面上的U+FFFD宽度为3,是合法的名字字符
+		if (utf8.RuneError == r) && (1 == width) {
+			return false
+		}
+
+		if !isNameStartChar(r) && ((0 == i) || !isNameChar(r)) {
+			return false
+		}
+		i += width
+	}
+
+	return true
+}
+
+// isNameStartChar 对应XML规范中的NameStartChar
+func isNameStartChar(r rune) bool {
+	return r == ':' ||
+		r >= 'A' && r <= 'Z' ||
+		r == '_' ||
+		r >= 'a' && r <= 'z' ||
+		r >= 0xC0 && r <= 0xD6 ||
+		r >= 0xD8 && r <= 0xF6 ||
+		r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D ||
+		r >= 0x37F && r <= 0x1FFF ||
+		r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F ||
+		r >= 0x2C00 && r <= 0x2FEF ||
+		r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF ||
+		r >= 0xFDF0 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0xEFFFF
+}
+
+// isNameChar 对应XML规范中的NameChar,不含NameStartChar部分
+func isNameChar(r rune) bool {
+	return r == '-' ||
+		r == '.' ||
+		r >= '0' && r <= '9' ||
+		r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F ||
+		r >= 0x203F && r <= 0x2040
+}
+
+// utf8BOM UTF-8编码的BOM头,PrintOptions.WriteBOM为true时输出在文档的最前面
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 // 最简洁的字符
 // 字符    属性    文本    转义
 // &       no     no     &amp;
 // <       no     no     &lt;
 // "       no     yes    &quot;
+// \t      no     yes    &#x9;
 // \n      no     yes    &#xA;
 // \r      no     yes    &#xD;
 // '       yes    yes    &apos;
@@ -1293,14 +5244,57 @@ func isInCharacterRange(r rune) (inrange bool) {
 var (
 	escAmps = []byte("&amp;")
 	escLt   = []byte("&lt;")
+	escGt   = []byte("&gt;")
 	escQuot = []byte("&quot;")
+	escApos = []byte("&apos;")
+	escTab  = []byte("&#x9;")
 	escNl   = []byte("&#xA;")
 	escCr   = []byte("&#xD;")
 	escFFFD = []byte("\uFFFD") // Unicode replacement character
 )
 
-// EscapeAttribute 对XMLElement中的属性值进行转义,常用于自定义文档输出格式
+// checkCharacters 检查s中是否含有XML 1.0(xml11为true时是XML 1.1)无法表示的字符,包括非法的UTF-8编码
+func checkCharacters(s []byte, xml11 bool) error {
+	return foreachInvalidChar(s, xml11, func(r rune, offset int) error {
+		return errors.New("Invalid XML character U+" + strings.ToUpper(strconv.FormatInt(int64(r), 16)) +
+			" at offset " + strconv.Itoa(offset))
+	})
+}
+
+// foreachInvalidChar 对s中每个XML 1.0(xml11为true时是XML 1.1)无法表示的字符调用callback,非法的UTF-8编码以utf8.RuneError的形式给出.
+// callback返回错误时停止并返回这个错误
+func foreachInvalidChar(s []byte, xml11 bool, callback func(r rune, offset int) error) error {
+	inRange := isInCharacterRange
+	if xml11 {
+		inRange = isInCharacterRange11
+	}
+
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		if !inRange(r) || (r == utf8.RuneError && width == 1) {
+			if err := callback(r, i); nil != err {
+				return err
+			}
+		}
+		i += width
+	}
+
+	return nil
+}
+
+// EscapeAttribute 对XMLElement中的属性值进行转义,常用于自定义文档输出格式.转义的结果适合放在双引号中
 func EscapeAttribute(w io.Writer, s []byte) error {
+	return EscapeAttributeQuote(w, s, '"')
+}
+
+// EscapeAttributeQuote 与EscapeAttribute相同,只是由quote指定属性值使用的引号:
+// quote为单引号时转义'为&apos;,双引号原样输出;否则按双引号处理,转义"为&quot;
+func EscapeAttributeQuote(w io.Writer, s []byte, quote byte) error {
+	quoteRune, quoteEsc := rune('"'), escQuot
+	if '\'' == quote {
+		quoteRune, quoteEsc = '\'', escApos
+	}
+
 	var esc []byte
 	last := 0
 	for i := 0; i < len(s); {
@@ -1311,8 +5305,10 @@ func EscapeAttribute(w io.Writer, s []byte) error {
 			esc = escAmps
 		case '<':
 			esc = escLt
-		case '"':
-			esc = escQuot
+		case quoteRune:
+			esc = quoteEsc
+		case '\t':
+			esc = escTab
 		case '\n':
 			esc = escNl
 		case '\r':
@@ -1340,6 +5336,11 @@ func EscapeAttribute(w io.Writer, s []byte) error {
 
 // EscapeText 对文本内容进行转义,常用于自定义文档输出格式
 func EscapeText(w io.Writer, s []byte) error {
+	return escapeText(w, s, false)
+}
+
+// escapeText 对文本内容进行转义,gt为true时>也转义成&gt;
+func escapeText(w io.Writer, s []byte, gt bool) error {
 	var esc []byte
 	last := 0
 	for i := 0; i < len(s); {
@@ -1350,6 +5351,11 @@ func EscapeText(w io.Writer, s []byte) error {
 			esc = escAmps
 		case '<':
 			esc = escLt
+		case '>':
+			if !gt {
+				continue
+			}
+			esc = escGt
 		default:
 			if !isInCharacterRange(r) || (r == 0xFFFD && width == 1) {
 				esc = escFFFD
@@ -1371,6 +5377,96 @@ func EscapeText(w io.Writer, s []byte) error {
 	return nil
 }
 
+// escapeXML11 按照XML 1.1的规则转义文本,quote不为0时转义的是使用quote作为引号的属性值,RestrictedChar输出成&#xNN;的形式,
+// gt为true时>也转义成&gt;
+func escapeXML11(w io.Writer, s []byte, quote byte, gt bool) error {
+	attr := 0 != quote
+	var esc []byte
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		switch {
+		case '&' == r:
+			esc = escAmps
+		case '<' == r:
+			esc = escLt
+		case gt && ('>' == r):
+			esc = escGt
+		case attr && ('\'' == quote) && ('\'' == r):
+			esc = escApos
+		case attr && ('\'' != quote) && ('"' == r):
+			esc = escQuot
+		case attr && ('\t' == r):
+			esc = escTab
+		case attr && ('\n' == r):
+			esc = escNl
+		case '\r' == r:
+			esc = escCr
+		case !isInCharacterRange11(r) || (r == 0xFFFD && width == 1):
+			esc = escFFFD
+		case isRestrictedChar(r):
+			esc = []byte("&#x" + strings.ToUpper(strconv.FormatInt(int64(r), 16)) + ";")
+		default:
+			continue
+		}
+		if _, err := w.Write(s[last : i-width]); err != nil {
+			return err
+		}
+		if _, err := w.Write(esc); err != nil {
+			return err
+		}
+		last = i
+	}
+	if _, err := w.Write(s[last:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EscapeASCII 在EscapeAttribute的基础上,将所有大于0x7F的字符都转义成&#xNN;形式的数字字符引用,输出的内容只含ASCII字符.
+// 常用于只能处理ASCII的老旧系统,既可以作为PrintOptions.Escaper也可以作为PrintOptions.AttributeEscaper使用
+func EscapeASCII(w io.Writer, s []byte) error {
+	var esc []byte
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		switch r {
+		case '&':
+			esc = escAmps
+		case '<':
+			esc = escLt
+		case '"':
+			esc = escQuot
+		case '\t':
+			esc = escTab
+		case '\n':
+			esc = escNl
+		case '\r':
+			esc = escCr
+		default:
+			if !isInCharacterRange(r) || (r == 0xFFFD && width == 1) {
+				r = 0xFFFD
+			} else if r <= 0x7F {
+				continue
+			}
+			esc = []byte("&#x" + strings.ToUpper(strconv.FormatInt(int64(r), 16)) + ";")
+		}
+		if _, err := w.Write(s[last : i-width]); err != nil {
+			return err
+		}
+		if _, err := w.Write(esc); err != nil {
+			return err
+		}
+		last = i
+	}
+	if _, err := w.Write(s[last:]); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Version 查询版本信息
 func Version() string {
 	return "1.2.0"
@@ -2,8 +2,12 @@ package tinydom
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"strings"
 	"testing"
@@ -884,3 +888,2355 @@ func Test_Attr_Order(t *testing.T) {
 	expect(t, "属性的顺序就是添加的顺序,不会应为key的不断变化而导致属性输出时,属性间的相对位置发生不断变化",
 	buf.String() == `<node attr5="55" attr2="22" attr3="33" attr4="44" attr6="66" attr9="99" attr=""/>`)
 }
+
+func Test_NodeToString(t *testing.T) {
+	doc, _ := LoadDocument(bytes.NewBufferString(`<node attr="1"><elem>text</elem><!--comment--></node>`))
+	node := doc.FirstChildElement("node")
+	expect(t, "输出整个文档", `<node attr="1"><elem>text</elem><!--comment--></node>` == NodeToString(doc, PrintStream))
+	expect(t, "只输出子树", `<elem>text</elem>` == NodeToString(node.FirstChildElement("elem"), PrintStream))
+	expect(t, "输出文本节点", `text` == NodeToString(node.FirstChildElement("elem").FirstChild(), PrintStream))
+	expect(t, "输出注释节点", `<!--comment-->` == NodeToString(node.LastChild(), PrintStream))
+	expect(t, "输出游离的文本节点", `a &lt; b` == NodeToString(NewText("a < b"), PrintStream))
+	expect(t, "nil节点输出空串", "" == NodeToString(nil, PrintStream))
+}
+
+func Test_Print_NormalizeAttributes(t *testing.T) {
+	elem := NewElement("elem")
+	elem.SetAttribute("attr", "a\tb\nc\r\nd\re")
+
+	expect(t, "缺省情况下不做规范化", `<elem attr="a&#x9;b&#xA;c&#xD;&#xA;d&#xD;e"/>` == NodeToString(elem, PrintStream))
+	expect(t, "规范化之后空白字符都变成了空格", `<elem attr="a b c d e"/>` == NodeToString(elem, PrintOptions{NormalizeAttributes: true}))
+	expect(t, "规范化不修改DOM中的属性值", "a\tb\nc\r\nd\re" == elem.Attribute("attr", ""))
+}
+
+func Test_Node_Depth(t *testing.T) {
+	doc, _ := LoadDocument(bytes.NewBufferString(`<!--comment--><root><elem1><elem2>text</elem2></elem1></root>`))
+	root := doc.FirstChildElement("root")
+	elem1 := root.FirstChildElement("elem1")
+	elem2 := elem1.FirstChildElement("elem2")
+	expect(t, "document的深度为0", 0 == doc.Depth())
+	expect(t, "根节点之前的注释深度为0", 0 == doc.FirstChild().Depth())
+	expect(t, "根元素深度为0", 0 == root.Depth())
+	expect(t, "elem1深度为1", 1 == elem1.Depth())
+	expect(t, "elem2深度为2", 2 == elem2.Depth())
+	expect(t, "文本节点深度为3", 3 == elem2.FirstChild().Depth())
+
+	elem1.Split()
+	expect(t, "游离节点的深度为0", 0 == elem1.Depth())
+	expect(t, "游离子树中的节点从游离节点开始计算", 1 == elem2.Depth())
+}
+
+func Test_Element_SetCDATAText(t *testing.T) {
+	doc, _ := LoadDocument(bytes.NewBufferString(`<root><script>old</script><style/></root>`))
+	script := doc.FirstChildElement("root").FirstChildElement("script")
+	style := doc.FirstChildElement("root").FirstChildElement("style")
+
+	script.SetCDATAText("if (a < b) {}")
+	expect(t, "已有的Text节点被修改并标记为CDATA", script.FirstChild() == script.LastChild())
+	expect(t, "已有的Text节点被修改并标记为CDATA", script.FirstChild().ToText().CDATA())
+	expect(t, "Text读取的是原始内容", "if (a < b) {}" == script.Text())
+
+	style.SetCDATAText("a > b")
+	expect(t, "没有Text节点时自动新建CDATA节点", style.FirstChild().ToText().CDATA())
+	expect(t, "检查输出", `<root><script><![CDATA[if (a < b) {}]]></script><style><![CDATA[a > b]]></style></root>` == NodeToString(doc, PrintStream))
+}
+
+func Test_Namespace_保留前缀(t *testing.T) {
+	xml := `<a:root xmlns:a="urn:a" xmlns="urn:default"><a:elem a:attr="1" attr="2"/><elem/></a:root>`
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "返回值检测", nil != doc)
+	expect(t, "返回值检测", nil == err)
+	expect(t, "名字空间前缀和声明都被保留", xml == NodeToString(doc, PrintStream))
+
+	doc, err = LoadDocument(strings.NewReader(`<a:root xmlns:a="urn:a"></b:root>`))
+	expect(t, "前缀不同的结束标签不匹配", nil == doc)
+	expect(t, "前缀不同的结束标签不匹配", nil != err)
+}
+
+func Test_Namespace_按名字空间查找(t *testing.T) {
+	xml := `<root xmlns="urn:default" xmlns:x="urn:one" xmlns:y="urn:one" xmlns:z="urn:two">
+		<y:item z:id="2" x:id="1" id="0"/>
+		<z:item/>
+		<item/>
+	</root>`
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "返回值检测", nil == err)
+
+	root := doc.FirstChildElementNS("urn:default", "root")
+	expect(t, "无前缀元素属于缺省名字空间", nil != root)
+	expect(t, "名字空间不匹配", nil == doc.FirstChildElementNS("urn:one", "root"))
+
+	item := root.FirstChildElementNS("urn:one", "item")
+	expect(t, "前缀不同但名字空间相同的元素可以匹配", nil != item && "y:item" == item.Name())
+	expect(t, "按名字空间查找", "z:item" == root.FirstChildElementNS("urn:two", "item").Name())
+	expect(t, "按名字空间查找", "item" == root.FirstChildElementNS("urn:default", "item").Name())
+	expect(t, "space为空时匹配任意名字空间", "y:item" == root.FirstChildElementNS("", "item").Name())
+	expect(t, "不存在的元素", nil == root.FirstChildElementNS("urn:none", "item"))
+
+	expect(t, "按名字空间读取属性", "1" == item.AttributeNS("urn:one", "id", "(default)"))
+	expect(t, "按名字空间读取属性", "2" == item.AttributeNS("urn:two", "id", "(default)"))
+	expect(t, "无前缀属性不属于缺省名字空间", "(default)" == item.AttributeNS("urn:default", "id", "(default)"))
+	expect(t, "space为空时匹配任意名字空间", "2" == item.AttributeNS("", "id", "(default)"))
+	expect(t, "xmlns声明属于xmlns名字空间", "urn:one" == root.AttributeNS("http://www.w3.org/2000/xmlns/", "x", ""))
+	expect(t, "xmlns声明属于xmlns名字空间", "urn:default" == root.AttributeNS("http://www.w3.org/2000/xmlns/", "xmlns", ""))
+}
+
+func Test_Namespace_按本地名查找(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<s:root xmlns:s="urn:s" xmlns:a="urn:a"><a:item id="1"/><item id="2"/><a:item id="3"/></s:root>`))
+
+	root := doc.FirstChildElement("root")
+	expect(t, "不带前缀的名字匹配本地名", (nil != root) && ("s:root" == root.Name()))
+	expect(t, "不带前缀的名字匹配本地名", "1" == root.FirstChildElement("item").Attribute("id", ""))
+	expect(t, "不带前缀的名字匹配本地名", "3" == root.LastChildElement("item").Attribute("id", ""))
+	expect(t, "不带前缀的名字匹配本地名", "2" == root.FirstChildElement("item").NextElement("item").Attribute("id", ""))
+	expect(t, "带前缀的名字完全匹配", "3" == root.FirstChildElement("a:item").NextElement("a:item").Attribute("id", ""))
+	expect(t, "前缀不同", nil == root.FirstChildElement("b:item"))
+	expect(t, "统计元素个数", (3 == root.CountElements("item")) && (2 == root.CountElements("a:item")))
+	expect(t, "句柄", "s:root" == NewHandle(doc).FirstChildElement("root").ToElement().Name())
+
+	second, third := root.FirstChildElement("item").NextElement("item"), root.LastChildElement("item")
+	expect(t, "带前缀的元素只与同名的带前缀元素一起计算序号", (2 == third.ElementIndex()) && ("/s:root/a:item[2]" == third.Path()))
+	expect(t, "不带前缀的元素与本地名相同的元素一起计算序号", (2 == second.ElementIndex()) && ("/s:root/item[2]" == second.Path()))
+	for _, elem := range []XMLElement{second, third} {
+		v, _ := Evaluate(doc, elem.Path())
+		expect(t, "按Path()找回元素:"+elem.Path(), elem == v.([]XMLNode)[0])
+	}
+
+	v, _ := Evaluate(doc, "count(/root/item)")
+	expect(t, "Evaluate与CountElements一致", (float64(3) == v) && (3 == root.CountElements("item")))
+	expect(t, "改名", (3 == RenameElements(doc, "item", "entry")) && (3 == root.CountElements("entry")))
+}
+
+func Test_Namespace_按本地名读写属性(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root xmlns:a="urn:a"><x a:id="1"/><y a:id="1" id="2"/></root>`))
+	x := doc.RootElement().FirstChildElement("x")
+	y := doc.RootElement().FirstChildElement("y")
+
+	expect(t, "不带前缀的名字匹配本地名", ("1" == x.Attribute("id", "DEF")) && x.HasAttribute("id") && ("1" == x.FindAttribute("id").Value()))
+	value, ok := x.LookupAttribute("id")
+	expect(t, "不带前缀的名字匹配本地名", ok && ("1" == value))
+	expect(t, "名字空间声明不参与匹配", "DEF" == doc.RootElement().Attribute("a", "DEF"))
+	expect(t, "带前缀的名字完全匹配", "DEF" == x.Attribute("b:id", "DEF"))
+
+	x.SetAttribute("id", "3")
+	expect(t, "修改已有的带前缀属性", (1 == x.AttributeCount()) && ("3" == x.Attribute("a:id", "")))
+	expect(t, "完全相同的名字优先", ("2" == y.Attribute("id", "")) && ("1" == y.Attribute("a:id", "")))
+	expect(t, "加载时两个属性都保留", `<y a:id="1" id="2"/>` == NodeToString(y, PrintStream))
+	expect(t, "复制时两个属性都保留", `<y a:id="1" id="2"/>` == NodeToString(CloneDocument(doc).RootElement().LastChild(), PrintStream))
+
+	x.DeleteAttribute("id")
+	expect(t, "删除带前缀的属性", 0 == x.AttributeCount())
+}
+
+func Test_Print_自定义转义函数(t *testing.T) {
+	elem := NewElement("elem")
+	elem.SetAttribute("attr", `中"文`)
+	elem.SetText("a<b>中文é")
+
+	expect(t, "缺省转义", `<elem attr="中&quot;文">a&lt;b>中文é</elem>` == NodeToString(elem, PrintStream))
+	expect(t, "ASCII转义", `<elem attr="&#x4E2D;&quot;&#x6587;">a&lt;b>&#x4E2D;&#x6587;&#xE9;</elem>` ==
+		NodeToString(elem, PrintOptions{Escaper: EscapeASCII, AttributeEscaper: EscapeASCII}))
+
+	raw := func(w io.Writer, s []byte) error {
+		_, err := w.Write(s)
+		return err
+	}
+	expect(t, "只替换文本的转义函数", `<elem attr="中&quot;文">a<b>中文é</elem>` == NodeToString(elem, PrintOptions{Escaper: raw}))
+	expect(t, "只替换属性的转义函数", `<elem attr="中"文">a&lt;b>中文é</elem>` == NodeToString(elem, PrintOptions{AttributeEscaper: raw}))
+}
+
+func Test_Element_HasAttribute_RemoveAttribute(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<node attr1="value1" attr2=""></node>`))
+	node := doc.FirstChildElement("node")
+
+	expect(t, "属性存在", node.HasAttribute("attr1"))
+	expect(t, "空值的属性也存在", node.HasAttribute("attr2"))
+	expect(t, "属性不存在", !node.HasAttribute("attr3"))
+
+	expect(t, "删除存在的属性返回true", node.RemoveAttribute("attr1"))
+	expect(t, "删除之后属性不存在了", !node.HasAttribute("attr1"))
+	expect(t, "重复删除返回false", !node.RemoveAttribute("attr1"))
+	expect(t, "删除不存在的属性返回false", !node.RemoveAttribute("attr3"))
+	expect(t, "元素个数", 1 == node.AttributeCount())
+}
+
+func Test_Document_非UTF8编码(t *testing.T) {
+	// ISO-8859-1编码的"café"
+	data := append([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><name>caf`), 0xE9, '<', '/', 'n', 'a', 'm', 'e', '>')
+
+	doc, err := LoadDocument(bytes.NewReader(data))
+	expect(t, "没有CharsetReader时无法解析", nil == doc)
+	expect(t, "没有CharsetReader时无法解析", nil != err)
+
+	latin1 := func(charset string, input io.Reader) (io.Reader, error) {
+		if "iso-8859-1" != strings.ToLower(charset) {
+			return nil, fmt.Errorf("unsupported charset: %s", charset)
+		}
+
+		datas, err := ioutil.ReadAll(input)
+		if nil != err {
+			return nil, err
+		}
+
+		runes := make([]rune, len(datas))
+		for i, b := range datas {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+
+	doc, err = LoadDocumentWithOptions(bytes.NewReader(data), LoadOptions{CharsetReader: latin1})
+	expect(t, "返回值检测", nil != doc)
+	expect(t, "返回值检测", nil == err)
+	expect(t, "转换成UTF-8", "café" == doc.FirstChildElement("name").Text())
+
+	// 转换之后的偏移量对应不上原始输入,不记录源码范围
+	data = append([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><r>`), 0xE9, 0xE9, 0xE9, 0xE9)
+	data = append(data, []byte(`<x/></r>`)...)
+	doc, err = LoadDocumentWithOptions(bytes.NewReader(data), LoadOptions{CharsetReader: latin1})
+	start, end := doc.RootElement().FirstChildElement("x").SourceRange()
+	expect(t, "转换编码之后没有源码范围", (nil == err) && (-1 == start) && (-1 == end))
+	start, end = doc.SourceRange()
+	expect(t, "转换编码之后没有源码范围", (-1 == start) && (-1 == end))
+
+	// 没有发生转换时仍然记录源码范围
+	utf8Data := `<?xml version="1.0" encoding="UTF-8"?><r>éééé<x/></r>`
+	doc, _ = LoadDocumentWithOptions(strings.NewReader(utf8Data), LoadOptions{CharsetReader: latin1})
+	start, end = doc.RootElement().FirstChildElement("x").SourceRange()
+	expect(t, "UTF-8输入的源码范围", (start >= 0) && ("<x/>" == utf8Data[start:end]))
+}
+
+func Test_FindAll_FindFirst(t *testing.T) {
+	xml := `<root><item id="1"/><group><item id="2"/><other/><item id="3"/></group><item id="4"/></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+
+	isItem := func(elem XMLElement) bool {
+		return "item" == elem.Name()
+	}
+
+	items := FindAll(doc, isItem)
+	expect(t, "找到所有的item", 4 == len(items))
+	for i, item := range items {
+		expect(t, "按照文档顺序返回", fmt.Sprint(i+1) == item.Attribute("id", ""))
+	}
+
+	expect(t, "root自身也参与匹配", 1 == len(FindAll(doc.FirstChildElement("root"), func(elem XMLElement) bool {
+		return "root" == elem.Name()
+	})))
+	expect(t, "找不到时返回空", 0 == len(FindAll(doc, func(elem XMLElement) bool { return false })))
+	expect(t, "nil节点", 0 == len(FindAll(nil, isItem)))
+
+	visited := 0
+	first := FindFirst(doc, func(elem XMLElement) bool {
+		visited++
+		return "group" == elem.Name()
+	})
+	expect(t, "找到第一个匹配的元素", nil != first && "group" == first.Name())
+	expect(t, "找到之后不再继续遍历", 3 == visited)
+
+	second := FindFirst(doc, func(elem XMLElement) bool {
+		return "item" == elem.Name() && "3" == elem.Attribute("id", "")
+	})
+	expect(t, "查找深层元素", nil != second && "3" == second.Attribute("id", ""))
+	expect(t, "找不到时返回nil", nil == FindFirst(doc, func(elem XMLElement) bool { return false }))
+}
+
+func Test_Document_XML声明(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0" encoding='utf-8' standalone="yes"?><root/>`))
+	expect(t, "读取version", "1.0" == doc.Version())
+	expect(t, "读取encoding", "utf-8" == doc.Encoding())
+	expect(t, "读取standalone", "yes" == doc.Standalone())
+
+	doc, _ = LoadDocument(strings.NewReader(`<?xml version="1.0"?><root/>`))
+	expect(t, "读取version", "1.0" == doc.Version())
+	expect(t, "没有的字段返回空串", "" == doc.Encoding())
+	expect(t, "没有的字段返回空串", "" == doc.Standalone())
+
+	doc, _ = LoadDocument(strings.NewReader(`<!--comment--><?other version="2.0"?><root/>`))
+	expect(t, "没有XML声明", "" == doc.Version())
+}
+
+func Test_Node_Count(t *testing.T) {
+	xml := `<root><item>text1</item><!--comment--><group><item/><other>text2</other></group></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	root := doc.FirstChildElement("root")
+
+	expect(t, "直接子节点个数", 3 == root.CountChildren())
+	expect(t, "document的直接子节点", 1 == doc.CountChildren())
+	expect(t, "子孙节点个数", 7 == root.CountDescendants())
+	expect(t, "document的子孙节点个数", 8 == doc.CountDescendants())
+	expect(t, "指定名字的元素个数", 2 == root.CountElements("item"))
+	expect(t, "所有元素个数", 5 == doc.CountElements(""))
+	expect(t, "不包括自身", 0 == root.CountElements("root"))
+
+	leaf := root.FirstChildElement("group").FirstChildElement("item")
+	expect(t, "叶子节点", 0 == leaf.CountChildren())
+	expect(t, "叶子节点", 0 == leaf.CountDescendants())
+	expect(t, "叶子节点", 0 == leaf.CountElements(""))
+}
+
+func Test_Node_Move(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/><b/><c/></root>`))
+	root := doc.FirstChildElement("root")
+	a := root.FirstChildElement("a")
+	b := root.FirstChildElement("b")
+	c := root.FirstChildElement("c")
+
+	expect(t, "移动到最后", a == a.MoveToEndChildOf(root))
+	expect(t, "检查顺序", `<root><b/><c/><a/></root>` == NodeToString(root, PrintStream))
+	expect(t, "移动到前面", c == c.MoveBefore(b))
+	expect(t, "检查顺序", `<root><c/><b/><a/></root>` == NodeToString(root, PrintStream))
+	expect(t, "移动到后面", c == c.MoveAfter(a))
+	expect(t, "检查顺序", `<root><b/><a/><c/></root>` == NodeToString(root, PrintStream))
+	expect(t, "移动到自己前面", b == b.MoveBefore(b))
+	expect(t, "检查顺序", `<root><b/><a/><c/></root>` == NodeToString(root, PrintStream))
+
+	expect(t, "sibling没有父节点", nil == a.MoveBefore(NewElement("x")))
+	expect(t, "sibling没有父节点", nil == a.MoveAfter(NewElement("x")))
+	expect(t, "parent为nil", nil == a.MoveToEndChildOf(nil))
+
+	// 跨文档移动,所有子孙节点的document都要更新
+	other, _ := LoadDocument(strings.NewReader(`<other><x><y><z/></y></x></other>`))
+	x := other.FirstChildElement("other").FirstChildElement("x")
+	z := x.FirstChildElement("y").FirstChildElement("z")
+	x.MoveAfter(b)
+	expect(t, "跨文档移动", `<root><b/><x><y><z/></y></x><a/><c/></root>` == NodeToString(root, PrintStream))
+	expect(t, "移动的节点属于新文档", doc == x.Document())
+	expect(t, "子孙节点也属于新文档", doc == z.Document())
+	expect(t, "原文档中已经没有了", other.FirstChildElement("other").NoChildren())
+}
+
+func Test_Node_插入子树时更新所有子孙节点的document(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+
+	// 在游离状态下构建子树
+	sub := NewElement("sub")
+	deep := sub.InsertElementEndChild("a").InsertElementEndChild("b")
+	text := deep.InsertEndChild(NewText("text"))
+	expect(t, "游离子树不属于任何文档", nil == deep.Document())
+
+	root.InsertEndChild(sub)
+	expect(t, "InsertEndChild", doc == sub.Document())
+	expect(t, "InsertEndChild", doc == deep.Document())
+	expect(t, "InsertEndChild", doc == text.Document())
+
+	sub.Split()
+	expect(t, "拆除之后子孙节点也不再属于文档", nil == deep.Document())
+	expect(t, "拆除之后子孙节点也不再属于文档", nil == text.Document())
+
+	root.InsertFirstChild(sub)
+	expect(t, "InsertFirstChild", doc == text.Document())
+	sub.Split()
+
+	anchor := root.InsertElementEndChild("anchor")
+	anchor.InsertFront(sub)
+	expect(t, "InsertFront", doc == text.Document())
+	sub.Split()
+
+	anchor.InsertBack(sub)
+	expect(t, "InsertBack", doc == text.Document())
+	root.DeleteChild(sub)
+	expect(t, "DeleteChild", nil == text.Document())
+}
+
+func Test_Print_文档序言部分的输出(t *testing.T) {
+	xml := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Copyright (c) tinydom. All rights reserved. -->
+<!DOCTYPE root [<!ELEMENT root (item)>]>
+<?xml-stylesheet type="text/xsl" href="style.xsl"?>
+<root>
+    <item/>
+</root>
+<!-- end of file -->`
+
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "返回值检测", nil == err)
+	expect(t, "序言部分的注释、指令、处理指令各占一行,且位于根元素之前", xml == NodeToString(doc, PrintPretty))
+
+	// 多次往返之后内容保持不变
+	again, err := LoadDocument(strings.NewReader(NodeToString(doc, PrintStream)))
+	expect(t, "返回值检测", nil == err)
+	expect(t, "往返之后保持不变", xml == NodeToString(again, PrintPretty))
+	expect(t, "版权注释仍然在最前面", nil != again.FirstChild().Next().ToComment())
+}
+
+func Test_Require(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<config><server port="8080"/></config>`))
+
+	config, err := doc.RequireChildElement("config")
+	expect(t, "元素存在", nil != config && nil == err)
+
+	server, err := config.RequireChildElement("server")
+	expect(t, "元素存在", nil != server && nil == err)
+
+	missing, err := config.RequireChildElement("client")
+	expect(t, "元素不存在", nil == missing && nil != err)
+	expect(t, "错误信息", "No child element named client under config" == err.Error())
+
+	_, err = server.RequireChildElement("")
+	expect(t, "没有任何子元素", nil != err && "No child element under server" == err.Error())
+
+	_, err = doc.RequireChildElement("other")
+	expect(t, "document下查找", nil != err && "No child element named other under document" == err.Error())
+
+	port, err := server.RequireAttribute("port")
+	expect(t, "属性存在", "8080" == port && nil == err)
+
+	host, err := server.RequireAttribute("host")
+	expect(t, "属性不存在", "" == host && nil != err)
+	expect(t, "错误信息", "No attribute named host on element server" == err.Error())
+}
+
+func Test_Print_LineEnding(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><item/></root>`))
+
+	expect(t, "缺省使用\\n", "<root>\n    <item/>\n</root>" == NodeToString(doc, PrintPretty))
+
+	crlf := PrintPretty
+	crlf.LineEnding = []byte("\r\n")
+	expect(t, "使用\\r\\n", "<root>\r\n    <item/>\r\n</root>" == NodeToString(doc, crlf))
+
+	stream := PrintStream
+	stream.LineEnding = []byte("\r\n")
+	expect(t, "不折行时不受影响", "<root><item/></root>" == NodeToString(doc, stream))
+}
+
+func Test_Node_TakeChildren(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><from><a/>text<!--c--></from><to/></root>`))
+	from := doc.FirstChildElement("root").FirstChildElement("from")
+	to := doc.FirstChildElement("root").FirstChildElement("to")
+
+	children := from.TakeChildren()
+	expect(t, "返回所有子节点", 3 == len(children))
+	expect(t, "按顺序返回", "a" == children[0].Value() && "text" == children[1].Value() && "c" == children[2].Value())
+	expect(t, "原节点没有子节点了", from.NoChildren() && nil == from.LastChild())
+	for _, child := range children {
+		expect(t, "完全游离", nil == child.Parent() && nil == child.Document())
+		expect(t, "没有残留的兄弟关系", nil == child.Prev() && nil == child.Next())
+	}
+
+	for _, child := range children {
+		to.InsertEndChild(child)
+	}
+	expect(t, "重新插入", `<root><from/><to><a/>text<!--c--></to></root>` == NodeToString(doc, PrintStream))
+	expect(t, "没有子节点时返回空", 0 == len(from.TakeChildren()))
+}
+
+func Test_DeepEqual(t *testing.T) {
+	load := func(s string) XMLDocument {
+		doc, err := LoadDocument(strings.NewReader(s))
+		expect(t, "加载文档:"+s, nil == err)
+		return doc
+	}
+
+	a := load(`<root a="1" b="2"><item>text</item><!--c--><?pi x?></root>`)
+	expect(t, "完全相同", DeepEqual(a, load(`<root a="1" b="2"><item>text</item><!--c--><?pi x?></root>`)))
+	expect(t, "缺省情况下属性顺序无关", DeepEqual(a, load(`<root b="2" a="1"><item>text</item><!--c--><?pi x?></root>`)))
+	expect(t, "指定属性顺序敏感", !DeepEqualWithOptions(a, load(`<root b="2" a="1"><item>text</item><!--c--><?pi x?></root>`), CompareOptions{AttributeOrder: true}))
+	expect(t, "指定属性顺序敏感", DeepEqualWithOptions(a, load(`<root a="1" b="2"><item>text</item><!--c--><?pi x?></root>`), CompareOptions{AttributeOrder: true}))
+
+	expect(t, "属性值不同", !DeepEqual(a, load(`<root a="1" b="3"><item>text</item><!--c--><?pi x?></root>`)))
+	expect(t, "属性个数不同", !DeepEqual(a, load(`<root a="1"><item>text</item><!--c--><?pi x?></root>`)))
+	expect(t, "元素名不同", !DeepEqual(a, load(`<root a="1" b="2"><other>text</other><!--c--><?pi x?></root>`)))
+	expect(t, "文本不同", !DeepEqual(a, load(`<root a="1" b="2"><item>TEXT</item><!--c--><?pi x?></root>`)))
+	expect(t, "注释不同", !DeepEqual(a, load(`<root a="1" b="2"><item>text</item><!--d--><?pi x?></root>`)))
+	expect(t, "处理指令不同", !DeepEqual(a, load(`<root a="1" b="2"><item>text</item><!--c--><?pi y?></root>`)))
+	expect(t, "子节点个数不同", !DeepEqual(a, load(`<root a="1" b="2"><item>text</item><!--c--></root>`)))
+	expect(t, "节点类型不同", !DeepEqual(a, load(`<root a="1" b="2"><item>text</item><?pi x?><!--c--></root>`)))
+	cdata := load(`<root a="1" b="2"><item>text</item><!--c--><?pi x?></root>`)
+	cdata.FirstChildElement("root").FirstChildElement("item").SetCDATAText("text")
+	expect(t, "CDATA标记不同", !DeepEqual(a, cdata))
+
+	b := load("<root><item>\n   some   text </item>\n <item/></root>")
+	c := load("<root><item>some text</item><item/></root>")
+	c.FirstChildElement("root").InsertFirstChild(NewText("   "))
+	expect(t, "缺省情况下空白敏感", !DeepEqual(b, c))
+	expect(t, "忽略空白", DeepEqualWithOptions(b, c, CompareOptions{IgnoreWhitespace: true}))
+
+	expect(t, "nil比较", DeepEqual(nil, nil))
+	expect(t, "nil比较", !DeepEqual(a, nil))
+	expect(t, "游离子树比较", DeepEqual(NewElement("x"), a.FirstChildElement("root").FirstChildElement("item").InsertBack(NewElement("x"))))
+}
+
+func Test_Print_NoSelfClose(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<div><p/><br/><span>text</span></div>`))
+
+	expect(t, "缺省自闭合", `<div><p/><br/><span>text</span></div>` == NodeToString(doc, PrintStream))
+	expect(t, "不自闭合", `<div><p></p><br></br><span>text</span></div>` == NodeToString(doc, PrintOptions{NoSelfClose: true}))
+	expect(t, "不自闭合但br例外", `<div><p></p><br/><span>text</span></div>` ==
+		NodeToString(doc, PrintOptions{NoSelfClose: true, SelfCloseExcept: map[string]bool{"br": true}}))
+	expect(t, "自闭合但p例外", `<div><p></p><br/><span>text</span></div>` ==
+		NodeToString(doc, PrintOptions{SelfCloseExcept: map[string]bool{"p": true}}))
+
+	pretty := PrintPretty
+	pretty.NoSelfClose = true
+	expect(t, "缩进模式下空元素的结束标签不换行", "<div>\n    <p></p>\n    <br></br>\n    <span>text</span>\n</div>" == NodeToString(doc, pretty))
+}
+
+func Test_FindElementsByText(t *testing.T) {
+	xml := `<books><book><name>The Moon</name><author>Tom</author></book><book>Go <b>west</b></book></books>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+
+	result := FindElementsByText(doc, "Moon", false)
+	expect(t, "只检查直接文本", 1 == len(result) && "name" == result[0].Name())
+
+	result = FindElementsByText(doc, "Moon", true)
+	expect(t, "检查所有子孙文本", 3 == len(result))
+	expect(t, "按照文档顺序", "books" == result[0].Name() && "book" == result[1].Name() && "name" == result[2].Name())
+
+	result = FindElementsByText(doc, "Go west", true)
+	expect(t, "跨越子元素拼接文本", 2 == len(result) && "book" == result[1].Name())
+	expect(t, "直接文本不含子元素的文本", 0 == len(FindElementsByText(doc, "Go west", false)))
+
+	result = FindElementsByTextFunc(doc, func(text string) bool {
+		return "Tom" == text
+	}, false)
+	expect(t, "自定义匹配函数", 1 == len(result) && "author" == result[0].Name())
+}
+
+func Test_Node_AddChild(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+
+	item := root.AddChild("item", map[string]string{"id": "1", "class": "a"}, "text")
+	expect(t, "返回新建的元素", nil != item && "item" == item.Name())
+	root.AddChild("empty", nil, "")
+	root.AddChildOrdered("ordered", "z", "1", "a", "2")
+	root.AddChildOrdered("noattr")
+	expect(t, "检查输出", `<root><item class="a" id="1">text</item><empty/><ordered z="1" a="2"/><noattr/></root>` == NodeToString(doc, PrintStream))
+
+	panicked := false
+	func() {
+		defer func() {
+			panicked = nil != recover()
+		}()
+		root.AddChildOrdered("bad", "key")
+	}()
+	expect(t, "参数个数为奇数时panic", panicked)
+}
+
+func Test_Node_SourceRange(t *testing.T) {
+	xml := `<?xml version="1.0"?><!--c--><root a="1"><item>text</item><empty/></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+
+	source := func(node XMLNode) string {
+		start, end := node.SourceRange()
+		return xml[start:end]
+	}
+
+	root := doc.FirstChildElement("root")
+	item := root.FirstChildElement("item")
+	expect(t, "document", xml == source(doc))
+	expect(t, "处理指令", `<?xml version="1.0"?>` == source(doc.FirstChild()))
+	expect(t, "注释", `<!--c-->` == source(doc.FirstChild().Next()))
+	expect(t, "元素包括开始标签到结束标签", `<root a="1"><item>text</item><empty/></root>` == source(root))
+	expect(t, "子元素", `<item>text</item>` == source(item))
+	expect(t, "文本", `text` == source(item.FirstChild()))
+	expect(t, "自闭合元素", `<empty/>` == source(root.LastChild()))
+
+	start, end := NewElement("new").SourceRange()
+	expect(t, "不是解析得到的节点", -1 == start && -1 == end)
+}
+
+func Test_RenameElements(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><old/><group><old>text</old><keep/></group></root>`))
+
+	expect(t, "返回改名的个数", 2 == RenameElements(doc, "old", "new"))
+	expect(t, "检查输出", `<root><new/><group><new>text</new><keep/></group></root>` == NodeToString(doc, PrintStream))
+	expect(t, "没有匹配的元素", 0 == RenameElements(doc, "old", "new"))
+
+	count := RenameElementsFunc(doc, func(elem XMLElement) bool {
+		return elem.NoChildren()
+	}, "leaf")
+	expect(t, "按条件改名", 2 == count)
+	expect(t, "检查输出", `<root><leaf/><group><new>text</new><leaf/></group></root>` == NodeToString(doc, PrintStream))
+}
+
+func Test_ParseFragment(t *testing.T) {
+	nodes, err := ParseFragment(`<item id="1"/>text<!--c--><item id="2"><sub/></item>`)
+	expect(t, "返回值检测", nil == err)
+	expect(t, "返回所有顶层节点", 4 == len(nodes))
+
+	for _, node := range nodes {
+		expect(t, "返回的节点都是游离的", nil == node.Parent() && nil == node.Document())
+	}
+
+	doc, _ := LoadDocument(strings.NewReader(`<root/>`))
+	root := doc.FirstChildElement("root")
+	for _, node := range nodes {
+		root.InsertEndChild(node)
+	}
+	expect(t, "插入到文档中", `<root><item id="1"/>text<!--c--><item id="2"><sub/></item></root>` == NodeToString(doc, PrintStream))
+	expect(t, "子孙节点属于新文档", doc == root.LastChildElement("item").FirstChild().Document())
+
+	nodes, err = ParseFragment("")
+	expect(t, "空片段", nil == err && 0 == len(nodes))
+
+	nodes, err = ParseFragment(`<a><b></a>`)
+	expect(t, "格式错误", nil != err && nil == nodes)
+
+	nodes, err = ParseFragment(`<a>`)
+	expect(t, "元素没有关闭", nil != err && nil == nodes)
+}
+
+func Test_MaxDepth(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a><b><c>text</c></b></a><d/></root>`))
+	root := doc.FirstChildElement("root")
+
+	expect(t, "document", 4 == MaxDepth(doc))
+	expect(t, "根元素", 4 == MaxDepth(root))
+	expect(t, "子树", 2 == MaxDepth(root.FirstChildElement("a").FirstChildElement("b")))
+	expect(t, "单个元素", 1 == MaxDepth(root.LastChildElement("d")))
+	expect(t, "文本节点", 0 == MaxDepth(NewText("text")))
+	expect(t, "nil", 0 == MaxDepth(nil))
+}
+
+func Test_Document_Snapshot(t *testing.T) {
+	xml := `<?xml version="1.0"?><!--c--><!DOCTYPE root><root a="1" b="2"><item>text</item><data/></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	doc.FirstChildElement("root").FirstChildElement("data").SetCDATAText("<raw>")
+
+	snapshot := doc.Snapshot()
+	expect(t, "内容完全相同", DeepEqualWithOptions(doc, snapshot, CompareOptions{AttributeOrder: true}))
+	expect(t, "所有节点都属于新文档", snapshot == snapshot.FirstChildElement("root").FirstChildElement("item").FirstChild().Document())
+	expect(t, "不共享节点", doc.FirstChildElement("root") != snapshot.FirstChildElement("root"))
+
+	// 修改原文档不影响快照
+	doc.FirstChildElement("root").SetAttribute("a", "changed")
+	doc.FirstChildElement("root").FirstChildElement("item").SetText("changed")
+	expect(t, "快照独立", "1" == snapshot.FirstChildElement("root").Attribute("a", ""))
+	expect(t, "快照独立", "text" == snapshot.FirstChildElement("root").FirstChildElement("item").Text())
+
+	// 多个goroutine并发读取快照
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- "text" == snapshot.FirstChildElement("root").FirstChildElement("item").Text()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		expect(t, "并发读取", <-done)
+	}
+}
+
+func Test_Element_空属性值_AttributeNames(t *testing.T) {
+	xml := `<a b="" c="1" d=""/>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	a := doc.FirstChildElement("a")
+
+	expect(t, "空值的属性存在", nil != a.FindAttribute("b") && "" == a.Attribute("b", "default"))
+	expect(t, "往返之后空值的属性原样保留", xml == NodeToString(doc, PrintStream))
+
+	names := a.AttributeNames()
+	expect(t, "按添加顺序返回属性名", 3 == len(names) && "b" == names[0] && "c" == names[1] && "d" == names[2])
+
+	a.DeleteAttribute("c")
+	a.SetAttribute("e", "")
+	names = a.AttributeNames()
+	expect(t, "修改之后的属性名", 3 == len(names) && "b" == names[0] && "d" == names[1] && "e" == names[2])
+	expect(t, "没有属性时返回空", 0 == len(NewElement("x").AttributeNames()))
+}
+
+func Test_Print_IndentSpaces_IndentTabs(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><item/></root>`))
+
+	expect(t, "2个空格缩进", "<root>\n  <item/>\n</root>" == NodeToString(doc, IndentSpaces(2)))
+	expect(t, "4个空格缩进等同于PrintPretty", NodeToString(doc, PrintPretty) == NodeToString(doc, IndentSpaces(4)))
+	expect(t, "1个tab缩进", "<root>\n\t<item/>\n</root>" == NodeToString(doc, IndentTabs(1)))
+	expect(t, "2个tab缩进", "<root>\n\t\t<item/>\n</root>" == NodeToString(doc, IndentTabs(2)))
+	expect(t, "0表示折行不缩进", "<root>\n<item/>\n</root>" == NodeToString(doc, IndentSpaces(0)))
+	expect(t, "负数等同于0", "<root>\n<item/>\n</root>" == NodeToString(doc, IndentTabs(-1)))
+}
+
+func Test_Element_IsEmpty(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/><b>text</b><c><!--comment--></c><d>  <x/>  </d><e attr="1">  x  </e></root>`))
+	root := doc.FirstChildElement("root")
+	elem := func(name string) XMLElement {
+		return root.FirstChildElement(name)
+	}
+
+	expect(t, "没有子节点", elem("a").IsEmpty() && !elem("a").HasElementChildren())
+	expect(t, "有文本", !elem("b").IsEmpty() && !elem("b").HasElementChildren())
+	expect(t, "只有注释", elem("c").IsEmpty() && !elem("c").HasElementChildren())
+	expect(t, "有子元素", !elem("d").IsEmpty() && elem("d").HasElementChildren())
+	expect(t, "有文本", !elem("e").IsEmpty())
+
+	ws := NewElement("ws")
+	ws.InsertEndChild(NewText(" \n\t "))
+	expect(t, "只有空白文本", ws.IsEmpty())
+	ws.SetCDATAText("   ")
+	expect(t, "只有空白的CDATA也视为空", ws.IsEmpty())
+	ws.SetCDATAText(" x ")
+	expect(t, "有内容的CDATA", !ws.IsEmpty())
+}
+
+func Test_Print_WriteBOM(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?><root><item/></root>`))
+
+	buf := bytes.NewBufferString("")
+	SaveDocument(doc, buf, PrintOptions{WriteBOM: true})
+	expect(t, "BOM在XML声明之前", "\xEF\xBB\xBF"+`<?xml version="1.0" encoding="UTF-8"?><root><item/></root>` == buf.String())
+
+	pretty := PrintPretty
+	pretty.WriteBOM = true
+	expect(t, "BOM只输出一次", "\xEF\xBB\xBF<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<root>\n    <item/>\n</root>" == NodeToString(doc, pretty))
+	expect(t, "缺省不输出BOM", !strings.HasPrefix(NodeToString(doc, PrintStream), "\xEF\xBB\xBF"))
+}
+
+func Test_Node_Normalize(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+	root.InsertEndChild(NewText("a"))
+	root.InsertEndChild(NewText(""))
+	root.InsertEndChild(NewText("b"))
+	root.InsertEndChild(NewText("c"))
+	cdata := NewText("d")
+	cdata.SetCDATA(true)
+	root.InsertEndChild(cdata)
+	cdata = NewText("e")
+	cdata.SetCDATA(true)
+	root.InsertEndChild(cdata)
+	root.InsertEndChild(NewText("f"))
+	sub := root.InsertElementEndChild("sub")
+	sub.InsertEndChild(NewText("x"))
+	sub.InsertEndChild(NewText("y"))
+	root.InsertEndChild(NewText(""))
+
+	doc.Normalize()
+	expect(t, "相邻的Text被合并", "abc" == root.Text())
+	expect(t, "CDATA与普通Text不合并,相邻的CDATA合并", 4 == root.CountChildren())
+	expect(t, "相邻的CDATA合并", "de" == root.FirstChild().Next().Value() && root.FirstChild().Next().ToText().CDATA())
+	expect(t, "递归处理", 1 == sub.CountChildren() && "xy" == sub.Text())
+	expect(t, "检查输出", `<root>abc<![CDATA[de]]>f<sub>xy</sub></root>` == NodeToString(doc, PrintStream))
+
+	empty := NewElement("empty")
+	empty.InsertEndChild(NewText(""))
+	empty.Normalize()
+	expect(t, "删除空的Text", empty.NoChildren())
+
+	gap := NewElement("gap")
+	cdata = NewText("d")
+	cdata.SetCDATA(true)
+	gap.InsertEndChild(cdata)
+	gap.InsertEndChild(NewText(""))
+	cdata = NewText("e")
+	cdata.SetCDATA(true)
+	gap.InsertEndChild(cdata)
+	gap.Normalize()
+	expect(t, "跨过空的Text合并", `<gap><![CDATA[de]]></gap>` == NodeToString(gap, PrintStream))
+}
+
+func Test_Document_MaxDepth(t *testing.T) {
+	xml := `<a><b><c><d/></c></b></a>`
+
+	doc, err := LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{MaxDepth: 4})
+	expect(t, "没有超过限制", nil != doc && nil == err)
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{MaxDepth: 3})
+	expect(t, "超过限制", nil == doc && nil != err)
+	expect(t, "错误信息包含层数和元素名", "Element nesting depth 4 exceeds the limit 3:d" == err.Error())
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(`<a><b/><b/><b/><b/></a>`), LoadOptions{MaxDepth: 2})
+	expect(t, "兄弟元素不会累加层数", nil != doc && nil == err)
+
+	doc, err = LoadDocument(strings.NewReader(strings.Repeat("<a>", 1000) + strings.Repeat("</a>", 1000)))
+	expect(t, "0表示不限制", nil != doc && nil == err)
+}
+
+func Test_Document_实体展开限制(t *testing.T) {
+	xml := `<root a="&amp;&lt;&#65;"><![CDATA[&amp;&amp;]]>&amp;&gt;&#x41;&big;</root>`
+	entity := map[string]string{"big": strings.Repeat("x", 100)}
+
+	doc, err := LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{Entity: entity})
+	expect(t, "自定义实体", nil == err && nil != doc)
+	expect(t, "自定义实体被展开", "&<A" == doc.FirstChildElement("root").Attribute("a", ""))
+
+	// 一共有5个实体引用:属性中2个,文本中3个,CDATA和字符引用不计入
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{Entity: entity, MaxEntityExpansions: 5})
+	expect(t, "没有超过个数限制", nil == err && nil != doc)
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{Entity: entity, MaxEntityExpansions: 4})
+	expect(t, "超过个数限制", nil != err && nil == doc)
+	expect(t, "错误信息", "Entity expansions exceed the limit 4" == err.Error())
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{Entity: entity, MaxEntityExpansionSize: 104})
+	expect(t, "没有超过大小限制", nil == err && nil != doc)
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{Entity: entity, MaxEntityExpansionSize: 103})
+	expect(t, "超过大小限制", nil != err && nil == doc)
+	expect(t, "错误信息", "Entity expansion size exceeds the limit 103" == err.Error())
+
+	// 输入流较大时,需要跨越多次读取统计
+	big := "<root>" + strings.Repeat("<item>&amp;</item>", 2000) + "</root>"
+	doc, err = LoadDocumentWithOptions(strings.NewReader(big), LoadOptions{MaxEntityExpansions: 2000})
+	expect(t, "大文档没有超过限制", nil == err && nil != doc)
+	doc, err = LoadDocumentWithOptions(strings.NewReader(big), LoadOptions{MaxEntityExpansions: 1999})
+	expect(t, "大文档超过限制", nil != err && nil == doc)
+
+	// CharsetReader转换编码之后内容变长了,统计的是转换之后的内容
+	latin1 := func(charset string, input io.Reader) (io.Reader, error) {
+		datas, _ := ioutil.ReadAll(input)
+		runes := make([]rune, len(datas))
+		for i, b := range datas {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	text := string(bytes.Repeat([]byte{0xE9}, 100))
+	data := `<?xml version="1.0" encoding="ISO-8859-1"?><root>` + text + `<x a="&amp;"/>` + text + `&amp;&lt;</root>`
+	doc, err = LoadDocumentWithOptions(strings.NewReader(data), LoadOptions{CharsetReader: latin1, MaxEntityExpansions: 3})
+	expect(t, "转换编码之后没有超过限制", nil == err && "&" == doc.RootElement().FirstChildElement("x").Attribute("a", ""))
+	doc, err = LoadDocumentWithOptions(strings.NewReader(data), LoadOptions{CharsetReader: latin1, MaxEntityExpansions: 2})
+	expect(t, "转换编码之后超过限制", nil != err && nil == doc)
+}
+
+func Test_CloneDocument(t *testing.T) {
+	xml := `<?xml version="1.0"?><!-- 注释 --><!DOCTYPE root><root a="1"><item b="2">文本<![CDATA[x]]></item><!-- 内部 --></root>`
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "加载文档", nil == err)
+
+	clone := CloneDocument(doc)
+	expect(t, "复制结果相同", DeepEqual(doc, clone))
+	expect(t, "输出相同", NodeToString(doc, PrintOptions{}) == NodeToString(clone, PrintOptions{}))
+
+	count := 0
+	var walk func(node XMLNode)
+	walk = func(node XMLNode) {
+		if node.Document() != clone {
+			count++
+		}
+		for child := node.FirstChild(); nil != child; child = child.Next() {
+			walk(child)
+		}
+	}
+	walk(clone)
+	expect(t, "所有节点都属于新文档", 0 == count)
+
+	clone.FirstChildElement("root").FirstChildElement("item").SetAttribute("b", "3")
+	clone.FirstChildElement("root").DeleteChildren()
+	expect(t, "修改复制品不影响原文档", "2" == doc.FirstChildElement("root").FirstChildElement("item").Attribute("b", ""))
+	expect(t, "nil文档", nil == CloneDocument(nil))
+}
+
+func Test_Node_WrapIn(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/><b/><c/></root>`))
+	root := doc.FirstChildElement("root")
+
+	// 头、中间、尾三种位置
+	root.FirstChildElement("a").WrapIn("x")
+	root.FirstChildElement("b").WrapIn("y")
+	wrapper := root.FirstChildElement("c").WrapIn("z")
+	expect(t, "包裹之后的结构", `<root><x><a/></x><y><b/></y><z><c/></z></root>` == NodeToString(doc, PrintOptions{}))
+	expect(t, "返回新建的元素", "z" == wrapper.Name() && root == wrapper.Parent() && root.LastChild() == wrapper)
+	expect(t, "被包裹的节点属于同一个文档", doc == wrapper.FirstChild().Document() && doc == wrapper.Document())
+
+	// 唯一子节点
+	wrapper = wrapper.FirstChildElement("c").WrapIn("w")
+	expect(t, "唯一子节点", `<z><w><c/></w></z>` == NodeToString(root.LastChild(), PrintOptions{}))
+
+	// 游离的节点和文档
+	elem := NewElement("free")
+	wrapper = elem.WrapIn("outer")
+	expect(t, "游离节点", nil == wrapper.Parent() && wrapper == elem.Parent())
+	expect(t, "文档不能被包裹", nil == doc.WrapIn("x"))
+}
+
+func Test_Element_Unwrap(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/><div><b/>文本<c><d/></c></div><e/></root>`))
+	root := doc.FirstChildElement("root")
+
+	root.FirstChildElement("div").Unwrap()
+	expect(t, "子节点提升到原来的位置", `<root><a/><b/>文本<c><d/></c><e/></root>` == NodeToString(doc, PrintOptions{}))
+	expect(t, "提升之后的父节点", root == root.FirstChildElement("c").Parent() && root == root.FirstChildElement("b").Parent())
+	expect(t, "提升之后的文档", doc == root.FirstChildElement("c").FirstChild().Document())
+
+	root.FirstChildElement("a").Unwrap()
+	expect(t, "没有子节点的元素直接删除", `<root><b/>文本<c><d/></c><e/></root>` == NodeToString(doc, PrintOptions{}))
+
+	root.FirstChildElement("c").Unwrap()
+	root.Unwrap()
+	expect(t, "根元素也可以展开", `<b/>文本<d/><e/>` == NodeToString(doc, PrintOptions{}))
+
+	elem := NewElement("free")
+	elem.InsertElementEndChild("child")
+	elem.Unwrap()
+	expect(t, "游离元素不变", 1 == elem.CountChildren())
+}
+
+// countingWriter 统计Write的调用次数,可以指定第几次调用开始返回错误
+type countingWriter struct {
+	calls  int
+	failAt int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if (w.failAt > 0) && (w.calls >= w.failAt) {
+		return 0, io.ErrShortWrite
+	}
+
+	return len(p), nil
+}
+
+func bigDocument(n int) XMLDocument {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+	for i := 0; i < n; i++ {
+		root.AddChildOrdered("item", "id", fmt.Sprint(i), "name", "<名字>").SetText("文本 & 内容")
+	}
+
+	return doc
+}
+
+func Test_SaveDocument_Buffered(t *testing.T) {
+	doc := bigDocument(100)
+
+	unbuffered := new(countingWriter)
+	doc.Accept(NewSimplePrinter(unbuffered, PrintPretty))
+
+	buffered := new(countingWriter)
+	err := SaveDocument(doc, buffered, PrintPretty)
+	expect(t, "保存成功", nil == err)
+	expect(t, "写入次数大幅减少", buffered.calls*10 < unbuffered.calls)
+
+	buf := bytes.NewBufferString("")
+	SaveDocument(doc, buf, PrintPretty)
+	expect(t, "内容与直接输出一致", NodeToString(doc, PrintPretty) == buf.String())
+
+	err = SaveDocument(doc, &countingWriter{failAt: 1}, PrintPretty)
+	expect(t, "写入错误会被返回", io.ErrShortWrite == err)
+}
+
+func Benchmark_SaveDocument_Unbuffered(b *testing.B) {
+	doc := bigDocument(10000)
+	file, err := ioutil.TempFile("", "tinydom")
+	if nil != err {
+		b.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file.Seek(0, os.SEEK_SET)
+		doc.Accept(NewSimplePrinter(file, PrintPretty))
+	}
+}
+
+func Benchmark_SaveDocument_Buffered(b *testing.B) {
+	doc := bigDocument(10000)
+	file, err := ioutil.TempFile("", "tinydom")
+	if nil != err {
+		b.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file.Seek(0, os.SEEK_SET)
+		SaveDocument(doc, file, PrintPretty)
+	}
+}
+
+func Test_TagCounts(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root id="0"><a id="1" x="1"/><b><a id="2"/><c/></b><!-- <a/> --><a/></root>`))
+
+	tags := TagCounts(doc)
+	expect(t, "元素个数", 4 == len(tags) && 1 == tags["root"] && 3 == tags["a"] && 1 == tags["b"] && 1 == tags["c"])
+
+	tags = TagCounts(doc.FirstChildElement("root").FirstChildElement("b"))
+	expect(t, "子树中的元素个数", 3 == len(tags) && 1 == tags["a"] && 1 == tags["b"] && 1 == tags["c"])
+
+	attrs := AttributeCounts(doc)
+	expect(t, "属性个数", 2 == len(attrs) && 3 == attrs["id"] && 1 == attrs["x"])
+
+	expect(t, "nil节点", 0 == len(TagCounts(nil)) && 0 == len(AttributeCounts(nil)))
+}
+
+func Test_Print_SkipCommentsAndDirectives(t *testing.T) {
+	xml := `<?xml version="1.0"?><!-- 内部备注 --><!DOCTYPE root><root><!-- 备注 --><a>文本</a><b><!-- 只有注释 --></b></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+
+	expect(t, "缺省保留所有内容", xml == NodeToString(doc, PrintOptions{}))
+
+	out := NodeToString(doc, PrintOptions{SkipComments: true})
+	expect(t, "去掉注释", `<?xml version="1.0"?><!DOCTYPE root><root><a>文本</a><b></b></root>` == out)
+
+	out = NodeToString(doc, PrintOptions{SkipDirectives: true})
+	expect(t, "去掉指令", `<?xml version="1.0"?><!-- 内部备注 --><root><!-- 备注 --><a>文本</a><b><!-- 只有注释 --></b></root>` == out)
+
+	options := PrintPretty
+	options.SkipComments = true
+	options.SkipDirectives = true
+	out = NodeToString(doc, options)
+	reloaded, err := LoadDocument(strings.NewReader(out))
+	expect(t, "输出可以重新解析", nil == err)
+
+	count := 0
+	var walk func(node XMLNode)
+	walk = func(node XMLNode) {
+		if (nil != node.ToComment()) || (nil != node.ToDirective()) {
+			count++
+		}
+		for child := node.FirstChild(); nil != child; child = child.Next() {
+			walk(child)
+		}
+	}
+	walk(reloaded)
+	expect(t, "重新解析后没有注释和指令", 0 == count)
+	expect(t, "其他内容不变", "文本" == strings.TrimSpace(reloaded.FirstChildElement("root").FirstChildElement("a").Text()))
+}
+
+func Test_Print_Canonical(t *testing.T) {
+	xml := `<?xml version="1.0"?>
+<!DOCTYPE doc>
+<!-- 前 -->
+<?pi-before?>
+<doc xmlns="http://example.com/default" xmlns:b="http://example.com/b" xmlns:a="http://example.com/a">
+	<e1 b:attr="1" z="2" a:attr="3" attr="4" xmlns:a="http://example.com/a"/>
+	<e2 xmlns=""><e3 xmlns="">文本&amp;&lt;&gt;<![CDATA[<cdata>]]></e3></e2>
+	<e4 note="a&#9;b&#10;c&quot;"/>
+</doc>
+<!-- 后 -->`
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "加载文档", nil == err)
+
+	expected := "<!-- 前 -->\n<?pi-before?>\n" +
+		`<doc xmlns="http://example.com/default" xmlns:a="http://example.com/a" xmlns:b="http://example.com/b">` +
+		`<e1 attr="4" z="2" a:attr="3" b:attr="1"></e1>` +
+		`<e2 xmlns=""><e3>文本&amp;&lt;&gt;&lt;cdata&gt;</e3></e2>` +
+		`<e4 note="a&#x9;b&#xA;c&quot;"></e4>` +
+		"</doc>\n<!-- 后 -->"
+	out := NodeToString(doc, PrintOptions{Canonical: true})
+	expect(t, "规范化输出", expected == out)
+
+	out = NodeToString(doc, PrintOptions{Canonical: true, SkipComments: true})
+	expect(t, "不带注释的规范化输出", strings.HasPrefix(out, "<?pi-before?>\n<doc") && strings.HasSuffix(out, "</doc>"))
+
+	// 输出子树时需要带上继承下来的名字空间声明
+	e1 := doc.FirstChildElement("doc").FirstChildElement("e1")
+	expected = `<e1 xmlns="http://example.com/default" xmlns:a="http://example.com/a" xmlns:b="http://example.com/b" attr="4" z="2" a:attr="3" b:attr="1"></e1>`
+	expect(t, "规范化输出子树", expected == NodeToString(e1, PrintOptions{Canonical: true}))
+
+	// 属性顺序、自闭合方式不同的文档规范化之后相同
+	other, _ := LoadDocument(strings.NewReader(`<r b="2" a="1"><x></x></r>`))
+	same, _ := LoadDocument(strings.NewReader(`<r a="1" b="2"><x/></r>`))
+	expect(t, "规范化之后相同", NodeToString(other, PrintOptions{Canonical: true}) == NodeToString(same, PrintOptions{Canonical: true}))
+}
+
+func Test_Element_SetAttributes(t *testing.T) {
+	elem := NewElement("item")
+	elem.SetAttribute("id", "0")
+	elem.SetAttributes("z", "1", "id", "2", "a", "3")
+	expect(t, "按参数顺序设置", `<item id="2" z="1" a="3"/>` == NodeToString(elem, PrintStream))
+
+	elem.SetAttributesMap(map[string]string{"y": "4", "b": "5", "z": "6"})
+	expect(t, "map中的属性按名字排序添加", `<item id="2" z="6" a="3" b="5" y="4"/>` == NodeToString(elem, PrintStream))
+
+	elem.SetAttributes()
+	elem.SetAttributesMap(nil)
+	expect(t, "没有参数", 5 == elem.AttributeCount())
+
+	panicked := false
+	func() {
+		defer func() {
+			panicked = nil != recover()
+		}()
+		elem.SetAttributes("key")
+	}()
+	expect(t, "参数个数为奇数时panic", panicked && 5 == elem.AttributeCount())
+}
+
+func Test_Print_MixedContent(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a>text</a><p>hello <b>world</b> end</p><div><e/><f><g/></f></div></root>`))
+
+	expected := "<root>\n" +
+		"    <a>text</a>\n" +
+		"    <p>hello <b>world</b> end</p>\n" +
+		"    <div>\n" +
+		"        <e/>\n" +
+		"        <f>\n" +
+		"            <g/>\n" +
+		"        </f>\n" +
+		"    </div>\n" +
+		"</root>"
+	expect(t, "含有文本的元素内部不折行", expected == NodeToString(doc, PrintPretty))
+
+	expected = "<root>\n<a>text</a>\n<p>hello <b>world</b> end</p>\n<div>\n<e/>\n<f>\n<g/>\n</f>\n</div>\n</root>"
+	expect(t, "折行不缩进", expected == NodeToString(doc, IndentSpaces(0)))
+
+	expected = `<root><a>text</a><p>hello <b>world</b> end</p><div><e/><f><g/></f></div></root>`
+	expect(t, "不折行", expected == NodeToString(doc, PrintStream))
+
+	// 重新解析之后文本内容不变
+	reloaded, _ := LoadDocument(strings.NewReader(NodeToString(doc, PrintPretty)))
+	p := reloaded.FirstChildElement("root").FirstChildElement("p")
+	expect(t, "文本内容不变", "hello " == p.FirstChild().Value() && " end" == p.LastChild().Value())
+	expect(t, "元素内的文本不变", "world" == p.FirstChildElement("b").Text())
+
+	// 输出单独的文本节点
+	text := NewText("text")
+	expect(t, "单独的文本节点", "text" == NodeToString(text, PrintPretty))
+}
+
+func Test_InnerXML(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><item id="1"><a/><b>x</b></item><text>a &amp; b</text><mixed>hello <b>world</b></mixed><empty/></root>`))
+	root := doc.FirstChildElement("root")
+
+	item := root.FirstChildElement("item")
+	expect(t, "只输出子节点", `<a/><b>x</b>` == InnerXML(item, PrintStream))
+	expect(t, "缩进模式", "<a/>\n<b>x</b>" == InnerXML(item, PrintPretty))
+
+	expect(t, "只含文本的元素输出转义之后的文本", "a &amp; b" == InnerXML(root.FirstChildElement("text"), PrintPretty))
+	expect(t, "混合内容不折行", "hello <b>world</b>" == InnerXML(root.FirstChildElement("mixed"), PrintPretty))
+	expect(t, "空元素", "" == InnerXML(root.FirstChildElement("empty"), PrintPretty))
+	expect(t, "nil元素", "" == InnerXML(nil, PrintPretty))
+
+	buf := bytes.NewBufferString("")
+	err := WriteInnerXML(item, buf, PrintStream)
+	expect(t, "输出到writer", nil == err && `<a/><b>x</b>` == buf.String())
+	expect(t, "写入错误会被返回", io.ErrShortWrite == WriteInnerXML(item, &countingWriter{failAt: 1}, PrintStream))
+}
+
+func Test_ValidName(t *testing.T) {
+	for _, name := range []string{"a", "_a", "a1", "a-b.c", "ns:item", ":x", "名字", "é", "a·b", "\uFFFD", "a\uFFFD"} {
+		expect(t, "合法的名字:"+name, ValidName(name))
+	}
+
+	for _, name := range []string{"", "1x", "-a", ".a", "bad name", "a<b", "a&b", "a\"", "a\tb", "\xff", "a\xffb", "a×"} {
+		expect(t, "非法的名字:"+name, !ValidName(name))
+	}
+
+	elem, err := NewElementE("item")
+	expect(t, "合法的元素名", nil == err && "item" == elem.Name())
+	elem, err = NewElementE("bad name")
+	expect(t, "非法的元素名", nil == elem && "Invalid element name:bad name" == err.Error())
+
+	proc, err := NewProcInstE("xml-stylesheet", `href="a.css"`)
+	expect(t, "合法的处理指令", nil == err && "xml-stylesheet" == proc.Target())
+	_, err = NewProcInstE("1pi", "")
+	expect(t, "非法的处理指令", nil != err)
+
+	root := NewElement("root")
+	attr, err := root.SetAttributeE("id", "1")
+	expect(t, "合法的属性名", nil == err && "1" == attr.Value())
+	attr, err = root.SetAttributeE("1x", "2")
+	expect(t, "非法的属性名", nil == attr && nil != err && 1 == root.AttributeCount())
+}
+
+func Test_ParseDoctype(t *testing.T) {
+	xml := `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><html/>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	doctype, err := ParseDoctype(doc.FirstChild().ToDirective())
+	expect(t, "PUBLIC", nil == err && "html" == doctype.Name && "-//W3C//DTD XHTML 1.0 Strict//EN" == doctype.PublicID)
+	expect(t, "PUBLIC的SYSTEM标识符", "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd" == doctype.SystemID && "" == doctype.InternalSubset)
+
+	doctype, err = ParseDoctype(NewDirective(`DOCTYPE note SYSTEM 'note.dtd'`))
+	expect(t, "SYSTEM", nil == err && "note" == doctype.Name && "" == doctype.PublicID && "note.dtd" == doctype.SystemID)
+
+	subset := `
+	<!ELEMENT root (#PCDATA)>
+	<!-- 注释中的] -->
+	<!ENTITY company "A]B &amp; Co">
+	<!ENTITY % param "p">
+	<!ENTITY logo SYSTEM "logo.gif">
+	<!ENTITY  copy  '&#169;'>
+	<!ENTITY company "重复">
+`
+	doctype, err = ParseDoctype(NewDirective("DOCTYPE root [" + subset + "]"))
+	expect(t, "内部子集", nil == err && "root" == doctype.Name && subset == doctype.InternalSubset)
+	expect(t, "实体声明", 2 == len(doctype.Entities) && "A]B &amp; Co" == doctype.Entities["company"] && "&#169;" == doctype.Entities["copy"])
+
+	doctype, err = ParseDoctype(NewDirective("DOCTYPE root"))
+	expect(t, "只有根元素名", nil == err && "root" == doctype.Name && 0 == len(doctype.Entities))
+
+	for _, bad := range []string{"ELEMENT root ANY", "DOCTYPE", "DOCTYPE root SYSTEM", "DOCTYPE root PUBLIC \"p\"", "DOCTYPE root [ <!ENTITY a 'b'>", "DOCTYPE root extra"} {
+		doctype, err = ParseDoctype(NewDirective(bad))
+		expect(t, "格式错误:"+bad, nil == doctype && nil != err)
+	}
+}
+
+func Test_Node_IsAncestorOf(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a><b>text</b></a><c/></root>`))
+	root := doc.FirstChildElement("root")
+	a := root.FirstChildElement("a")
+	b := a.FirstChildElement("b")
+	text := b.FirstChild()
+	c := root.FirstChildElement("c")
+
+	expect(t, "文档是所有节点的祖先", doc.IsAncestorOf(root) && doc.IsAncestorOf(text))
+	expect(t, "父节点和更上层的祖先", a.IsAncestorOf(b) && a.IsAncestorOf(text) && root.IsAncestorOf(text))
+	expect(t, "子孙节点", text.IsDescendantOf(a) && b.IsDescendantOf(doc))
+	expect(t, "不是自己的祖先和子孙", !a.IsAncestorOf(a) && !a.IsDescendantOf(a))
+	expect(t, "兄弟节点", !a.IsAncestorOf(c) && !c.IsAncestorOf(b) && !c.IsDescendantOf(a))
+	expect(t, "反向关系", !b.IsAncestorOf(a) && !a.IsDescendantOf(b))
+	expect(t, "nil节点", !a.IsAncestorOf(nil) && !a.IsDescendantOf(nil))
+
+	b.Split()
+	expect(t, "拆除之后", !root.IsAncestorOf(b) && b.IsAncestorOf(text))
+}
+
+func Test_Node_Path(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><!--c--><root><items><item/><item><name>n</name></item><item/><!--x-->text</items></root>`))
+	root := doc.FirstChildElement("root")
+	items := root.FirstChildElement("items")
+	item := items.FirstChildElement("item").NextElement("item")
+
+	expect(t, "文档", "/" == doc.Path())
+	expect(t, "根元素", "/root" == root.Path())
+	expect(t, "唯一的子元素不带序号", "/root/items" == items.Path())
+	expect(t, "同名元素带序号", "/root/items/item[2]/name" == item.FirstChildElement("name").Path())
+	expect(t, "文本节点", "/root/items/item[2]/name/text()[1]" == item.FirstChildElement("name").FirstChild().Path())
+	expect(t, "注释和文本", "/root/items/comment()[1]" == items.LastChild().Prev().Path() && "/root/items/text()[1]" == items.LastChild().Path())
+	expect(t, "文档级别的节点", "/processing-instruction()[1]" == doc.FirstChild().Path() && "/comment()[1]" == doc.FirstChild().Next().Path())
+
+	item.Split()
+	expect(t, "游离子树", "item/name" == item.FirstChildElement("name").Path() && "item" == item.Path())
+	expect(t, "删除之后序号变化", "/root/items/item[2]" == items.LastChildElement("item").Path())
+}
+
+func Test_Print_StrictChars(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+	root.SetAttribute("a", "ok")
+	root.InsertElementEndChild("item").SetText("a\x00b")
+
+	buf := bytes.NewBufferString("")
+	err := SaveDocument(doc, buf, PrintOptions{})
+	expect(t, "缺省替换成U+FFFD", nil == err && strings.Contains(buf.String(), "a�b"))
+
+	err = SaveDocument(doc, bytes.NewBufferString(""), PrintOptions{StrictChars: true})
+	expect(t, "文本中的非法字符", nil != err && "Invalid XML character U+0 at offset 1" == err.Error())
+
+	root.FirstChildElement("item").SetText("ok")
+	root.SetAttribute("b", "x\x01")
+	err = SaveDocument(doc, bytes.NewBufferString(""), PrintOptions{StrictChars: true})
+	expect(t, "属性值中的非法字符", nil != err && "Invalid XML character U+1 at offset 1" == err.Error())
+
+	root.SetAttribute("b", "x")
+	root.FirstChildElement("item").SetCDATAText("\xff")
+	err = SaveDocument(doc, bytes.NewBufferString(""), PrintOptions{StrictChars: true})
+	expect(t, "CDATA中的非法编码", nil != err)
+	expect(t, "InnerXML也会返回错误", nil != WriteInnerXML(root, bytes.NewBufferString(""), PrintOptions{StrictChars: true}))
+
+	root.FirstChildElement("item").SetText("合法\t字符\n")
+	buf.Reset()
+	err = SaveDocument(doc, buf, PrintOptions{StrictChars: true})
+	expect(t, "没有非法字符", nil == err && NodeToString(doc, PrintOptions{}) == buf.String())
+}
+
+func Test_CharacterRange(t *testing.T) {
+	for _, r := range []rune{0x09, 0x0A, 0x0D, 0x20, 0xD7FF, 0xE000, 0xFFFD, 0x10000, 0x10FFFF} {
+		expect(t, fmt.Sprintf("XML 1.0合法字符 %#x", r), isInCharacterRange(r))
+	}
+	for _, r := range []rune{0x00, 0x01, 0x1F, 0xD800, 0xDF77, 0xDFFF, 0xFFFE, 0xFFFF, 0x110000} {
+		expect(t, fmt.Sprintf("XML 1.0非法字符 %#x", r), !isInCharacterRange(r))
+	}
+
+	for _, r := range []rune{0x01, 0x1F, 0x7F, 0xD7FF, 0xE000, 0x10FFFF} {
+		expect(t, fmt.Sprintf("XML 1.1合法字符 %#x", r), isInCharacterRange11(r))
+	}
+	for _, r := range []rune{0x00, 0xD800, 0xDFFF, 0xFFFE} {
+		expect(t, fmt.Sprintf("XML 1.1非法字符 %#x", r), !isInCharacterRange11(r))
+	}
+
+	elem := NewElement("item")
+	elem.SetAttribute("a", "x\x01\"\ny")
+	elem.SetText("a\x00b\x1fc\u0085d\u0080e&")
+	expect(t, "XML 1.0替换成U+FFFD", `<item a="x�&quot;&#xA;y">a�b�c`+"\u0085d\u0080e&amp;</item>" == NodeToString(elem, PrintOptions{}))
+	expect(t, "XML 1.1输出成字符引用", `<item a="x&#x1;&quot;&#xA;y">a�b&#x1F;c`+"\u0085d&#x80;e&amp;</item>" == NodeToString(elem, PrintOptions{XML11: true}))
+
+	err := SaveDocument(doc11(elem), bytes.NewBufferString(""), PrintOptions{XML11: true, StrictChars: true})
+	expect(t, "XML 1.1中NUL仍然非法", nil != err && "Invalid XML character U+0 at offset 1" == err.Error())
+
+	elem.SetText("a\x1fb")
+	err = SaveDocument(doc11(elem), bytes.NewBufferString(""), PrintOptions{XML11: true, StrictChars: true})
+	expect(t, "XML 1.1中控制字符合法", nil == err)
+}
+
+func doc11(elem XMLElement) XMLDocument {
+	doc := NewDocument()
+	doc.InsertEndChild(NewProcInst("xml", `version="1.1"`))
+	doc.InsertEndChild(elem)
+	return doc
+}
+
+func Test_SetInnerXML(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><placeholder><old/></placeholder></root>`))
+	elem := doc.FirstChildElement("root").FirstChildElement("placeholder")
+
+	err := SetInnerXML(elem, `<a id="1">x</a>文本<!--c--><b/>`)
+	expect(t, "替换子节点", nil == err && `<a id="1">x</a>文本<!--c--><b/>` == InnerXML(elem, PrintStream))
+	expect(t, "新节点属于文档", doc == elem.FirstChildElement("a").FirstChild().Document() && elem == elem.LastChild().Parent())
+
+	err = SetInnerXML(elem, `<a><b></a>`)
+	expect(t, "解析失败时不修改", nil != err && `<a id="1">x</a>文本<!--c--><b/>` == InnerXML(elem, PrintStream))
+
+	err = SetInnerXML(elem, "")
+	expect(t, "空串清空子节点", nil == err && elem.NoChildren())
+
+	expect(t, "nil元素", nil != SetInnerXML(nil, "<a/>"))
+}
+
+func Test_StreamWriter(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	w := NewStreamWriter(buf, PrintPretty)
+	w.StartElement("report")
+	w.Attr("title", `a<b & "c"`)
+	w.Comment("生成的报表")
+	for i := 1; i <= 2; i++ {
+		w.StartElement("row")
+		w.Attr("id", fmt.Sprint(i))
+		w.StartElement("name")
+		w.Text("名字 & 值")
+		w.EndElement()
+		w.StartElement("empty")
+		w.EndElement()
+		w.EndElement()
+	}
+	w.StartElement("mixed")
+	w.Text("hello ")
+	w.StartElement("b")
+	w.Text("world")
+	err := w.Close()
+
+	expected := "<report title=\"a&lt;b &amp; &quot;c&quot;\">\n" +
+		"    <!--生成的报表-->\n" +
+		"    <row id=\"1\">\n" +
+		"        <name>名字 &amp; 值</name>\n" +
+		"        <empty/>\n" +
+		"    </row>\n" +
+		"    <row id=\"2\">\n" +
+		"        <name>名字 &amp; 值</name>\n" +
+		"        <empty/>\n" +
+		"    </row>\n" +
+		"    <mixed>hello <b>world</b></mixed>\n" +
+		"</report>"
+	expect(t, "缩进输出", nil == err && expected == buf.String())
+
+	// 输出结果与构造DOM树之后打印的结果一致
+	doc, err := LoadDocument(strings.NewReader(buf.String()))
+	expect(t, "输出可以重新解析", nil == err)
+	expect(t, "与DOM打印一致", expected == NodeToString(doc, PrintPretty))
+
+	buf.Reset()
+	w = NewStreamWriter(buf, PrintOptions{NoSelfClose: true})
+	w.StartElement("a")
+	w.StartElement("b")
+	expect(t, "不缩进,Close结束所有元素", nil == w.Close() && "<a><b></b></a>" == buf.String())
+
+	w = NewStreamWriter(bytes.NewBufferString(""), PrintStream)
+	w.StartElement("a")
+	w.Text("x")
+	err = w.Attr("id", "1")
+	expect(t, "属性必须紧跟在StartElement之后", nil != err && err == w.EndElement() && err == w.Close())
+
+	w = NewStreamWriter(bytes.NewBufferString(""), PrintStream)
+	expect(t, "非法的元素名", nil != w.StartElement("bad name"))
+	w = NewStreamWriter(bytes.NewBufferString(""), PrintStream)
+	expect(t, "没有可以结束的元素", nil != w.EndElement())
+	w = NewStreamWriter(&countingWriter{failAt: 1}, PrintStream)
+	w.StartElement("a")
+	expect(t, "写入错误", io.ErrShortWrite == w.Close())
+}
+
+func Test_Element_CollapsedText(t *testing.T) {
+	xml := `<config>
+	<name>
+		张三
+		李四
+	</name>
+	<split>a<!--c-->b  <![CDATA[ c ]]></split>
+	<mixed> hello <b>world</b>  end </mixed>
+	<empty>   </empty>
+</config>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	config := doc.FirstChildElement("config")
+
+	expect(t, "去掉首尾空白并合并空白", "张三 李四" == config.FirstChildElement("name").CollapsedText())
+	expect(t, "拼接多个文本节点", "ab c" == config.FirstChildElement("split").CollapsedText())
+	expect(t, "不含子元素中的文本", "hello end" == config.FirstChildElement("mixed").CollapsedText())
+	expect(t, "只有空白", "" == config.FirstChildElement("empty").CollapsedText())
+	expect(t, "没有文本", "" == config.CollapsedText())
+}
+
+func Test_Print_EmptyProcInst(t *testing.T) {
+	xml := `<?target?><?pi a="1"?><root><?php echo 1; ?></root>`
+	doc, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "加载文档", nil == err)
+	expect(t, "空指令内容", "" == doc.FirstChild().ToProcInst().Instruction())
+	expect(t, "原样输出", xml == NodeToString(doc, PrintStream))
+
+	// 目标之后只有空白时,空白只是分隔符,指令内容同样为空
+	doc, _ = LoadDocument(strings.NewReader(`<?php ?><root/>`))
+	expect(t, "只有空白的指令", `<?php?><root/>` == NodeToString(doc, PrintStream))
+
+	expect(t, "新建的处理指令", `<?target?>` == NodeToString(NewProcInst("target", ""), PrintStream))
+	expect(t, "非空的处理指令", `<?target data?>` == NodeToString(NewProcInst("target", "data"), PrintStream))
+}
+
+func Test_Element_DeclareNamespace(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+	expect(t, "声明缺省名字空间", nil == root.DeclareNamespace("", "urn:default"))
+	expect(t, "声明前缀", nil == root.DeclareNamespace("a", "urn:a"))
+	item := root.InsertElementEndChild("a:item")
+	expect(t, "子元素上重新声明", nil == item.DeclareNamespace("a", "urn:a2"))
+	child := item.InsertElementEndChild("b:child")
+	child.DeclareNamespace("b", "urn:b")
+
+	expect(t, "输出", `<root xmlns="urn:default" xmlns:a="urn:a"><a:item xmlns:a="urn:a2"><b:child xmlns:b="urn:b"/></a:item></root>` == NodeToString(doc, PrintStream))
+	expect(t, "向上查找", "urn:a2" == child.LookupNamespaceURI("a") && "urn:default" == child.LookupNamespaceURI("") && "urn:a" == root.LookupNamespaceURI("a"))
+	expect(t, "预定义的前缀", xmlNamespaceURI == child.LookupNamespaceURI("xml"))
+	expect(t, "找不到", "" == root.LookupNamespaceURI("b"))
+	expect(t, "NS查找", child == item.FirstChildElementNS("urn:b", "child"))
+
+	expect(t, "删除声明", item.UndeclareNamespace("a") && "urn:a" == child.LookupNamespaceURI("a"))
+	expect(t, "删除不存在的声明", !item.UndeclareNamespace("a"))
+
+	expect(t, "保留的前缀", nil != root.DeclareNamespace("xml", "urn:x") && nil != root.DeclareNamespace("xmlns", "urn:x"))
+	expect(t, "非法的前缀", nil != root.DeclareNamespace("1a", "urn:x") && nil != root.DeclareNamespace("a:b", "urn:x"))
+	expect(t, "前缀的URI不能为空", nil != root.DeclareNamespace("c", ""))
+	expect(t, "缺省名字空间可以为空", nil == child.DeclareNamespace("", "") && "" == child.LookupNamespaceURI(""))
+}
+
+func Test_Diff(t *testing.T) {
+	a, _ := LoadDocument(strings.NewReader(`<config version="1" mode="a"><item id="1">x</item><item id="2"/><old/><!--c--></config>`))
+	b, _ := LoadDocument(strings.NewReader(`<config version="2" extra="e"><item id="1">y</item><item id="2"/><item id="3"/><!--c--><new>n</new></config>`))
+
+	changes := Diff(a, b)
+	expected := []Change{
+		{ChangeModified, "/config/@version", "1", "2"},
+		{ChangeRemoved, "/config/@mode", "a", ""},
+		{ChangeAdded, "/config/@extra", "", "e"},
+		{ChangeModified, "/config/item[1]/text()[1]", "x", "y"},
+		{ChangeRemoved, "/config/old", "<old/>", ""},
+		{ChangeAdded, "/config/item[3]", "", `<item id="3"/>`},
+		{ChangeAdded, "/config/new", "", "<new>n</new>"},
+	}
+	expect(t, "变化个数", len(expected) == len(changes))
+	for i := 0; (i < len(expected)) && (i < len(changes)); i++ {
+		expect(t, fmt.Sprintf("第%d处变化:%v", i, changes[i]), expected[i] == changes[i])
+	}
+
+	expect(t, "相同的文档没有变化", 0 == len(Diff(a, CloneDocument(a))))
+
+	root := a.FirstChildElement("config")
+	changes = Diff(root, b.FirstChildElement("config").FirstChildElement("item"))
+	expect(t, "根节点不同", 2 == len(changes) && ChangeRemoved == changes[0].Kind && ChangeAdded == changes[1].Kind)
+
+	changes = Diff(nil, root)
+	expect(t, "a为nil", 1 == len(changes) && ChangeAdded == changes[0].Kind && "/config" == changes[0].Path)
+	expect(t, "都为nil", 0 == len(Diff(nil, nil)))
+}
+
+func Test_Print_InlineText(t *testing.T) {
+	doc := NewDocument()
+	person := doc.InsertElementEndChild("person")
+	person.InsertElementEndChild("name").SetText("John")
+	person.InsertElementEndChild("note").SetText("第一行\n第二行")
+	person.InsertElementEndChild("script").SetCDATAText("a < b")
+	person.InsertElementEndChild("address").InsertElementEndChild("city").SetText("Paris")
+
+	expected := "<person>\n" +
+		"\t<name>John</name>\n" +
+		"\t<note>第一行\n第二行</note>\n" +
+		"\t<script><![CDATA[a < b]]></script>\n" +
+		"\t<address>\n" +
+		"\t\t<city>Paris</city>\n" +
+		"\t</address>\n" +
+		"</person>"
+	expect(t, "只有一个文本子节点的元素输出在同一行", expected == NodeToString(doc, IndentTabs(1)))
+
+	reloaded, _ := LoadDocument(strings.NewReader(NodeToString(doc, IndentTabs(1))))
+	expect(t, "重新解析之后文本不变", "John" == reloaded.FirstChildElement("person").FirstChildElement("name").Text())
+}
+
+func Test_StripNamespaces(t *testing.T) {
+	xml := `<s:root xmlns:s="urn:s" xmlns="urn:d" xml:lang="zh"><s:item s:id="1" b="2"><child a:id="3" id="4" xmlns:a="urn:a"/></s:item></s:root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+
+	conflicts := StripNamespaces(doc)
+	expect(t, "去掉前缀和声明", `<root xml:lang="zh"><item id="1" b="2"><child a:id="3" id="4"/></item></root>` == NodeToString(doc, PrintStream))
+	expect(t, "报告冲突的属性", 1 == len(conflicts) && "/root/item/child/@a:id" == conflicts[0])
+
+	// 输出的文档可以被重新解析,并且没有任何名字空间声明
+	reloaded, err := LoadDocument(strings.NewReader(NodeToString(doc, PrintStream)))
+	expect(t, "可以重新解析", nil == err && "1" == reloaded.FirstChildElement("root").FirstChildElement("item").Attribute("id", ""))
+
+	elem := NewElement("a:x")
+	expect(t, "没有冲突", nil == StripNamespaces(elem) && "x" == elem.Name())
+	expect(t, "nil节点", nil == StripNamespaces(nil))
+}
+
+func Test_Text_Raw(t *testing.T) {
+	elem := NewElement("p")
+	text := NewText("a &lt; b &amp; <b>c</b>")
+	elem.InsertEndChild(text)
+	expect(t, "缺省不是Raw", !text.Raw())
+	expect(t, "缺省会转义", `<p>a &amp;lt; b &amp;amp; &lt;b>c&lt;/b></p>` == NodeToString(elem, PrintStream))
+
+	text.SetRaw(true)
+	expect(t, "Raw文本原样输出", `<p>a &lt; b &amp; <b>c</b></p>` == NodeToString(elem, PrintStream))
+	expect(t, "规范化输出也原样输出", `<p>a &lt; b &amp; <b>c</b></p>` == NodeToString(elem, PrintOptions{Canonical: true}))
+
+	reloaded, _ := LoadDocument(strings.NewReader(NodeToString(elem, PrintStream)))
+	expect(t, "重新解析之后只反转义一次", "a < b & " == reloaded.FirstChildElement("p").Text())
+
+	clone := CloneDocument(doc11(elem))
+	expect(t, "复制时保留Raw标记", clone.LastChild().FirstChild().ToText().Raw())
+
+	elem.InsertEndChild(NewText("&"))
+	elem.Normalize()
+	expect(t, "Raw文本不与普通文本合并", 2 == elem.CountChildren())
+}
+
+func Test_Element_LookupAttribute(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<item id="1" empty=""/>`))
+	item := doc.FirstChildElement("item")
+
+	value, ok := item.LookupAttribute("id")
+	expect(t, "存在的属性", ok && "1" == value)
+	value, ok = item.LookupAttribute("empty")
+	expect(t, "值为空串的属性", ok && "" == value)
+	value, ok = item.LookupAttribute("missing")
+	expect(t, "不存在的属性", !ok && "" == value)
+
+	item.RemoveAttribute("id")
+	_, ok = item.LookupAttribute("id")
+	expect(t, "删除之后", !ok)
+}
+
+func Test_NodeTokens(t *testing.T) {
+	source := `<?xml version="1.0"?><!--c--><!DOCTYPE root><a:root xmlns:a="urn:a" id="1"><item a:x="2">text</item><empty/></a:root>`
+	doc, _ := LoadDocument(strings.NewReader(source))
+
+	tokens := NodeTokens(doc)
+	expect(t, "Token个数", 10 == len(tokens))
+	start, ok := tokens[3].(xml.StartElement)
+	expect(t, "元素名带前缀", ok && "a:root" == start.Name.Local && "" == start.Name.Space)
+	expect(t, "属性", 2 == len(start.Attr) && "xmlns:a" == start.Attr[0].Name.Local && "1" == start.Attr[1].Value)
+	expect(t, "文本", "text" == string(tokens[5].(xml.CharData)))
+	expect(t, "结束标签", "a:root" == tokens[9].(xml.EndElement).Name.Local)
+
+	// 交给xml.Encoder输出的结果与tinydom的输出一致
+	buf := bytes.NewBufferString("")
+	encoder := xml.NewEncoder(buf)
+	for _, token := range tokens {
+		expect(t, "Encoder接受所有的Token", nil == encoder.EncodeToken(token))
+	}
+	encoder.Flush()
+	expect(t, "Encoder的输出", NodeToString(doc, PrintOptions{NoSelfClose: true}) == buf.String())
+
+	expect(t, "子树", 3 == len(NodeTokens(doc.FirstChildElement("a:root").FirstChildElement("item"))))
+	expect(t, "nil节点", 0 == len(NodeTokens(nil)))
+}
+
+func Test_LoadDocumentFromTokens(t *testing.T) {
+	source := `<?xml version="1.0"?><!--c--><!DOCTYPE root><a:root xmlns:a="urn:a" id="1"><item a:x="2">text</item><empty/></a:root>`
+	doc, _ := LoadDocument(strings.NewReader(source))
+
+	// 与NodeTokens互为逆操作
+	rebuilt, err := LoadDocumentFromTokens(NodeTokens(doc))
+	expect(t, "从Token构建", nil == err && DeepEqual(doc, rebuilt))
+	start, end := rebuilt.FirstChildElement("a:root").SourceRange()
+	expect(t, "没有源码范围", -1 == start && -1 == end)
+
+	// 直接使用xml.Decoder作为TokenReader,RawToken的前缀放在Space中
+	decoder := xml.NewDecoder(strings.NewReader(source))
+	rebuilt, err = LoadDocumentFromTokenReader(rawTokenReader{decoder})
+	expect(t, "从Decoder构建", nil == err && source == NodeToString(rebuilt, PrintStream))
+
+	// *xml.Decoder本身也满足TokenReader,Token()会解析名字空间,前缀被换成了URI
+	rebuilt, err = LoadDocumentFromTokenReader(xml.NewDecoder(strings.NewReader(`<root><item>x</item></root>`)))
+	expect(t, "直接传入Decoder", nil == err && `<root><item>x</item></root>` == NodeToString(rebuilt, PrintStream))
+
+	tokens := []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "root"}},
+		xml.CharData("  "),
+		xml.StartElement{Name: xml.Name{Local: "item"}, Attr: []xml.Attr{{Name: xml.Name{Space: "p", Local: "id"}, Value: "1"}}},
+		xml.EndElement{Name: xml.Name{Local: "item"}},
+		xml.EndElement{Name: xml.Name{Local: "root"}},
+	}
+	rebuilt, err = LoadDocumentFromTokens(tokens)
+	expect(t, "手工构造的Token", nil == err && `<root><item p:id="1"/></root>` == NodeToString(rebuilt, PrintStream))
+
+	_, err = LoadDocumentFromTokens(tokens[:4])
+	expect(t, "元素没有关闭", nil != err)
+	_, err = LoadDocumentFromTokens(append(tokens[:3:3], xml.EndElement{Name: xml.Name{Local: "x"}}))
+	expect(t, "结束标签不匹配", nil != err)
+	_, err = LoadDocumentFromTokens(nil)
+	expect(t, "没有根元素", nil != err)
+	_, err = LoadDocumentFromTokens(append(tokens, tokens...))
+	expect(t, "多个根元素", nil != err)
+}
+
+// rawTokenReader 使用RawToken读取,保留元素和属性的前缀
+type rawTokenReader struct {
+	decoder *xml.Decoder
+}
+
+func (r rawTokenReader) Token() (xml.Token, error) {
+	return r.decoder.RawToken()
+}
+
+func Test_Print_AttributeQuote(t *testing.T) {
+	elem := NewElement("item")
+	elem.SetAttribute("a", `say "hi" it's`)
+
+	expect(t, "缺省使用双引号", `<item a="say &quot;hi&quot; it's"/>` == NodeToString(elem, PrintOptions{}))
+	expect(t, "使用单引号", `<item a='say "hi" it&apos;s'/>` == NodeToString(elem, PrintOptions{AttributeQuote: '\''}))
+	expect(t, "不支持的引号按双引号处理", `<item a="say &quot;hi&quot; it's"/>` == NodeToString(elem, PrintOptions{AttributeQuote: '`'}))
+	expect(t, "XML 1.1模式", `<item a='say "hi" it&apos;s'/>` == NodeToString(elem, PrintOptions{AttributeQuote: '\'', XML11: true}))
+
+	reloaded, err := LoadDocument(strings.NewReader(NodeToString(elem, PrintOptions{AttributeQuote: '\''})))
+	expect(t, "重新解析", nil == err && `say "hi" it's` == reloaded.FirstChildElement("item").Attribute("a", ""))
+
+	buf := bytes.NewBufferString("")
+	w := NewStreamWriter(buf, PrintOptions{AttributeQuote: '\''})
+	w.StartElement("item")
+	w.Attr("a", `'"`)
+	expect(t, "流式输出", nil == w.Close() && `<item a='&apos;"'/>` == buf.String())
+
+	buf.Reset()
+	EscapeAttributeQuote(buf, []byte(`'"&`), '\'')
+	expect(t, "EscapeAttributeQuote", `&apos;"&amp;` == buf.String())
+}
+
+func Test_Element_Attributes(t *testing.T) {
+	elem := NewElement("item")
+	expect(t, "没有属性", 0 == len(elem.Attributes()))
+
+	elem.SetAttributes("z", "1", "a", "2", "m", "3")
+	attrs := elem.Attributes()
+	expect(t, "按添加的顺序", 3 == len(attrs) && "z" == attrs[0].Name() && "a" == attrs[1].Name() && "3" == attrs[2].Value())
+
+	attrs[0], attrs[1] = attrs[1], attrs[0]
+	expect(t, "重新排列不影响元素", "a" == attrs[0].Name() && "z" == elem.Attributes()[0].Name())
+
+	attrs[0].SetValue("changed")
+	expect(t, "属性对象是共享的", "changed" == elem.Attribute("a", ""))
+}
+
+func Test_Print_ColorHooks(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root id="1"><item name="a&amp;b">x &lt; y</item><empty/>c</root>`))
+	wrap := func(tag string) func([]byte) []byte {
+		return func(s []byte) []byte {
+			return []byte("[" + tag + ":" + string(s) + "]")
+		}
+	}
+
+	options := PrintOptions{TagColor: wrap("t"), AttrNameColor: wrap("n"), AttrValueColor: wrap("v"), TextColor: wrap("x")}
+	expected := `[t:<root] [n:id]=[v:"1"][t:>][t:<item] [n:name]=[v:"a&amp;b"][t:>][x:x &lt; y][t:</item>]` +
+		`[t:<empty][t:/>][x:c][t:</root>]`
+	expect(t, "着色输出", expected == NodeToString(doc, options))
+	expect(t, "没有设置时输出不变", `<root id="1"><item name="a&amp;b">x &lt; y</item><empty/>c</root>` == NodeToString(doc, PrintOptions{}))
+
+	options.Indent = []byte("  ")
+	doc, _ = LoadDocument(strings.NewReader(`<a><b/></a>`))
+	expect(t, "缩进不受影响", "[t:<a][t:>]\n  [t:<b][t:/>]\n[t:</a>]" == NodeToString(doc, options))
+
+	buf := bytes.NewBufferString("")
+	w := NewStreamWriter(buf, PrintOptions{TagColor: wrap("t"), TextColor: wrap("x")})
+	w.StartElement("a")
+	w.Attr("k", "v")
+	w.Text("t")
+	expect(t, "流式输出着色", nil == w.Close() && `[t:<a] k="v"[t:>][x:t][t:</a>]` == buf.String())
+}
+
+func Test_Print_MixedSiblingOrder(t *testing.T) {
+	src := `<?xml version="1.0"?><!--head--><!DOCTYPE r><r><!--c1--><?pi x?><a/><!--c2--><b><!--in--></b>` +
+		`<!DOCTYPE x><c><?p?><!--only--></c></r><!--tail-->`
+	doc, _ := LoadDocument(strings.NewReader(src))
+	expect(t, "不缩进时原样输出", src == NodeToString(doc, PrintOptions{}))
+
+	expected := "<?xml version=\"1.0\"?>\n<!--head-->\n<!DOCTYPE r>\n<r>\n  <!--c1-->\n  <?pi x?>\n  <a/>\n  <!--c2-->\n  <b>\n    <!--in-->\n  </b>\n" +
+		"  <!DOCTYPE x>\n  <c>\n    <?p?>\n    <!--only-->\n  </c>\n</r>\n<!--tail-->"
+	pretty := NodeToString(doc, PrintOptions{Indent: []byte("  ")})
+	expect(t, "缩进输出保持兄弟节点的顺序", expected == pretty)
+
+	again, err := LoadDocument(strings.NewReader(pretty))
+	expect(t, "缩进输出可以再次解析并且结构不变", (nil == err) && (src == NodeToString(again, PrintOptions{})))
+
+	expected = "<?xml version=\"1.0\"?>\n<r>\n  <?pi x?>\n  <a/>\n  <b></b>\n  <c>\n    <?p?>\n  </c>\n</r>"
+	expect(t, "子节点全部被跳过时结束标签不折行", expected == NodeToString(doc, PrintOptions{Indent: []byte("  "), SkipComments: true, SkipDirectives: true}))
+}
+
+func Test_LoadDocuments(t *testing.T) {
+	stream := `<?xml version="1.0"?><record id="1"><msg>a</msg></record>
+<?xml version="1.0"?><!-- 第二条 --><record id="2"/><record id="3">c</record><!-- 结束 -->
+`
+	docs, err := LoadDocuments(strings.NewReader(stream))
+	expect(t, "解析多个文档", (nil == err) && (3 == len(docs)))
+	expect(t, "第一个文档", `<?xml version="1.0"?><record id="1"><msg>a</msg></record>` == NodeToString(docs[0], PrintOptions{}))
+	expect(t, "根元素之前的节点属于下一个文档", `<?xml version="1.0"?><!-- 第二条 --><record id="2"/>` == NodeToString(docs[1], PrintOptions{}))
+	expect(t, "最后剩下的节点属于最后一个文档", `<record id="3">c</record><!-- 结束 -->` == NodeToString(docs[2], PrintOptions{}))
+
+	start, end := docs[2].SourceRange()
+	expect(t, "SourceRange相对于整个输入流", `<record id="3">c</record>` == stream[start:end])
+
+	docs, err = LoadDocuments(strings.NewReader(" \n"))
+	expect(t, "空的输入流", (nil == err) && (0 == len(docs)))
+
+	_, err = LoadDocuments(strings.NewReader(`<a/><!-- only -->`))
+	expect(t, "最后只有注释也可以", nil == err)
+
+	_, err = LoadDocuments(strings.NewReader(`<!-- only -->`))
+	expect(t, "没有根元素", nil != err)
+
+	_, err = LoadDocuments(strings.NewReader(`<a/><b>`))
+	expect(t, "最后一个文档没有结束", nil != err)
+}
+
+func Test_Element_SameTag(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><r a="1" b="2"><x/></r><r b="2" a="1">text</r><r a="1"/><r a="1" b="3"/><s a="1" b="2"/></root>`))
+	var items []XMLElement
+	for elem := doc.FirstChildElement("root").FirstChildElement(""); nil != elem; elem = elem.NextElement("") {
+		items = append(items, elem)
+	}
+
+	expect(t, "属性顺序和子节点不影响比较", items[0].SameTag(items[1]) && items[1].SameTag(items[0]))
+	expect(t, "属性个数不同", !items[0].SameTag(items[2]) && !items[2].SameTag(items[0]))
+	expect(t, "属性值不同", !items[0].SameTag(items[3]))
+	expect(t, "元素名不同", !items[0].SameTag(items[4]))
+	expect(t, "和自己比较", items[0].SameTag(items[0]))
+	expect(t, "和nil比较", !items[0].SameTag(nil))
+}
+
+func Test_Print_EscapeGreaterThan(t *testing.T) {
+	doc := NewDocument()
+	root := NewElement("root")
+	root.SetAttribute("a", "x>y")
+	root.SetText("a > b && c ]]> d")
+	doc.InsertEndChild(root)
+
+	expect(t, "缺省不转义>", `<root a="x>y">a > b &amp;&amp; c ]]> d</root>` == NodeToString(doc, PrintOptions{}))
+	expect(t, "转义文本中的>", `<root a="x>y">a &gt; b &amp;&amp; c ]]&gt; d</root>` == NodeToString(doc, PrintOptions{EscapeGreaterThan: true}))
+	expect(t, "XML 1.1也转义>", `<root a="x>y">a &gt; b &amp;&amp; c ]]&gt; d</root>` == NodeToString(doc, PrintOptions{EscapeGreaterThan: true, XML11: true}))
+
+	buf := bytes.NewBufferString("")
+	EscapeText(buf, []byte("1 > 0"))
+	expect(t, "EscapeText的行为不变", "1 > 0" == buf.String())
+}
+
+func Test_Parser(t *testing.T) {
+	parser := NewParser(LoadOptions{MaxDepth: 2})
+	for i := 0; i < 3; i++ {
+		doc, err := parser.Parse(strings.NewReader(fmt.Sprintf(`<record id="%d"><v>x</v></record>`, i)))
+		expect(t, "重复使用同一个Parser", (nil == err) && (fmt.Sprint(i) == doc.FirstChildElement("record").Attribute("id", "")))
+
+		start, end := doc.FirstChildElement("record").SourceRange()
+		expect(t, "SourceRange正确", (0 == start) && (32 == end))
+	}
+
+	_, err := parser.Parse(strings.NewReader(`<a><b><c/></b></a>`))
+	expect(t, "使用创建时的选项", nil != err)
+
+	_, err = parser.Parse(strings.NewReader(`<a>`))
+	expect(t, "出错之后", nil != err)
+
+	doc, err := parser.Parse(strings.NewReader(`<a>ok</a>`))
+	expect(t, "出错之后仍然可以使用", (nil == err) && ("ok" == doc.FirstChildElement("a").Text()))
+}
+
+// plainReader 隐藏了底层Reader的ReadByte等方法,模拟文件、网络连接等普通的io.Reader
+type plainReader struct {
+	io.Reader
+}
+
+func Benchmark_LoadDocument_Small(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		LoadDocument(plainReader{strings.NewReader(`<record id="1"><v>x</v></record>`)})
+	}
+}
+
+func Benchmark_Parser_Small(b *testing.B) {
+	parser := NewParser(LoadOptions{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parser.Parse(plainReader{strings.NewReader(`<record id="1"><v>x</v></record>`)})
+	}
+}
+
+func Test_Node_Type(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><!DOCTYPE root><!--c--><root>text</root>`))
+	expect(t, "文档", DocumentNode == doc.Type())
+
+	var types []NodeType
+	for child := doc.FirstChild(); nil != child; child = child.Next() {
+		types = append(types, child.Type())
+	}
+	expect(t, "顶层节点", fmt.Sprint([]NodeType{ProcInstNode, DirectiveNode, CommentNode, ElementNode}) == fmt.Sprint(types))
+	expect(t, "文本", TextNode == doc.FirstChildElement("root").FirstChild().Type())
+
+	cdata := NewText("x")
+	cdata.SetCDATA(true)
+	expect(t, "CDATA也是文本", TextNode == cdata.Type())
+}
+
+func Test_LoadDocumentGzip(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	zw := gzip.NewWriter(buf)
+	zw.Write([]byte(`<?xml version="1.0"?><root><item>压缩</item></root>`))
+	zw.Close()
+	compressed := buf.Bytes()
+
+	doc, err := LoadDocumentGzip(bytes.NewReader(compressed))
+	expect(t, "解析gzip压缩的文档", (nil == err) && ("压缩" == doc.FirstChildElement("root").FirstChildElement("item").Text()))
+
+	_, err = LoadDocumentGzip(strings.NewReader(`<root/>`))
+	expect(t, "没有压缩的输入", nil != err)
+
+	for _, content := range [][]byte{compressed, []byte(`<root><item>压缩</item></root>`)} {
+		file, _ := ioutil.TempFile("", "tinydom")
+		file.Write(content)
+		file.Close()
+
+		doc, err = LoadDocumentFromFileAuto(file.Name())
+		os.Remove(file.Name())
+		expect(t, "自动识别文件是否压缩", (nil == err) && ("压缩" == doc.FirstChildElement("root").FirstChildElement("item").Text()))
+	}
+
+	_, err = LoadDocumentFromFileAuto(os.TempDir() + "/tinydom-not-exist.xml.gz")
+	expect(t, "文件不存在", nil != err)
+}
+
+func Test_Print_AttrPerLine(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<layout a="1" b="2"><view id="v"/><view id="w" c="3"/><text x="1" y="2">hi</text></layout>`))
+	options := PrintOptions{Indent: []byte("  "), AttrPerLine: true}
+	expected := "<layout\n  a=\"1\"\n  b=\"2\">\n  <view id=\"v\"/>\n  <view\n    id=\"w\"\n    c=\"3\"/>\n  <text\n    x=\"1\"\n    y=\"2\">hi</text>\n</layout>"
+	out := NodeToString(doc, options)
+	expect(t, "每个属性单独占一行", expected == out)
+
+	again, err := LoadDocument(strings.NewReader(out))
+	expect(t, "输出可以重新解析", (nil == err) && DeepEqual(doc, again))
+
+	expect(t, "不折行时不受影响", `<layout a="1" b="2">` == NodeToString(doc, PrintOptions{AttrPerLine: true})[:20])
+}
+
+func Test_Element_SetNameE(t *testing.T) {
+	elem := NewElement("old")
+	expect(t, "合法的名字", (nil == elem.SetNameE("ns:new-name")) && ("ns:new-name" == elem.Name()))
+
+	for _, name := range []string{"", "1abc", "a b", "<x>"} {
+		expect(t, "不合法的名字:"+name, (nil != elem.SetNameE(name)) && ("ns:new-name" == elem.Name()))
+	}
+}
+
+func Test_Document_RootElement(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><!--c--><!DOCTYPE root><root><a/></root><!--tail-->`))
+	root := doc.RootElement()
+	expect(t, "跳过根元素之前的节点", (nil != root) && ("root" == root.Name()))
+
+	expect(t, "空文档没有根元素", nil == NewDocument().RootElement())
+
+	doc = NewDocument()
+	doc.InsertEndChild(NewComment("only"))
+	expect(t, "只有注释的文档没有根元素", nil == doc.RootElement())
+}
+
+func Test_Load_MixedContentWhitespace(t *testing.T) {
+	load := func(xml string) string {
+		doc, err := LoadDocument(strings.NewReader(xml))
+		if nil != err {
+			return err.Error()
+		}
+		return NodeToString(doc, PrintOptions{})
+	}
+
+	expect(t, "混合内容中的空白全部保留", `<p>a <b>b</b> <i>c</i> </p>` == load(`<p>a <b>b</b> <i>c</i> </p>`))
+	expect(t, "行内元素之间的空格保留", `<p><b>b</b> <i>c</i></p>` == load(`<p><b>b</b> <i>c</i></p>`))
+	expect(t, "首尾的空白被丢弃", `<p><b>b</b></p>` == load(`<p> <b>b</b> </p>`))
+	expect(t, "缩进被丢弃", `<list><item>1</item><item>2</item></list>` == load("<list>\n  <item>1</item>\n  <item>2</item>\n</list>"))
+	expect(t, "只有空白的元素", `<e/>` == load(`<e>  </e>`))
+	expect(t, "混合内容中的缩进也保留", "<p>\n  text <b>b</b>\n</p>" == load("<p>\n  text <b>b</b>\n</p>"))
+}
+
+func Test_Load_PreserveWhitespace(t *testing.T) {
+	load := func(xml string, options LoadOptions) string {
+		doc, err := LoadDocumentWithOptions(strings.NewReader(xml), options)
+		if nil != err {
+			return err.Error()
+		}
+		return NodeToString(doc, PrintOptions{})
+	}
+
+	preserve := LoadOptions{PreserveWhitespace: true}
+	expect(t, "缺省丢弃含有换行的空白", `<p><b>x</b><i>y</i></p>` == load("<p><b>x</b>\n<i>y</i></p>", LoadOptions{}))
+	expect(t, "保留行内元素之间的换行", "<p><b>x</b>\n<i>y</i></p>" == load("<p><b>x</b>\n<i>y</i></p>", preserve))
+	expect(t, "保留缩进", "<list>\n  <item>1</item>\n</list>" == load("<list>\n  <item>1</item>\n</list>", preserve))
+	expect(t, "保留只有空白的元素", `<e>  </e>` == load(`<e>  </e>`, preserve))
+	expect(t, "顶层的空白仍然丢弃", `<?xml version="1.0"?><r> </r>` == load("<?xml version=\"1.0\"?>\n<r> </r>\n", preserve))
+
+	doc, _ := LoadDocumentWithOptions(strings.NewReader("<p><b>x</b>\n<i>y</i></p>"), preserve)
+	expect(t, "文本内容", "x\ny" == innerText(doc.RootElement()))
+
+	fragment := LoadOptions{PreserveWhitespace: true, AllowFragment: true}
+	expect(t, "片段顶层的空白保留", "<a/>\n<b/>" == load("<a/>\n<b/>", fragment))
+}
+
+// elementCounter 嵌入BaseVisitor,只重写需要的接口
+type elementCounter struct {
+	BaseVisitor
+	elements int
+}
+
+func (c *elementCounter) VisitEnterElement(elem XMLElement) bool {
+	c.elements++
+	return true
+}
+
+func Test_BaseVisitor(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><!--c--><root><a>text</a><b/></root>`))
+	counter := &elementCounter{}
+	expect(t, "遍历整个文档", doc.Accept(counter) && (3 == counter.elements))
+
+	var visitor XMLVisitor = BaseVisitor{}
+	expect(t, "不重写任何接口", doc.Accept(visitor))
+}
+
+func Test_SerializedSize(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><root a="x&quot;y"><item>a &amp; b &lt; 中文</item><empty/><!--c--></root>`))
+	for _, options := range []PrintOptions{{}, PrintPretty, {NoSelfClose: true, WriteBOM: true}, {Canonical: true}, {XML11: true, AttributeQuote: '\''}} {
+		expect(t, "与实际输出的字节数相同", len(NodeToString(doc, options)) == SerializedSize(doc, options))
+	}
+
+	item := doc.RootElement().FirstChildElement("item")
+	expect(t, "子树", len(`<item>a &amp; b &lt; 中文</item>`) == SerializedSize(item, PrintOptions{}))
+	expect(t, "nil", 0 == SerializedSize(nil, PrintOptions{}))
+}
+
+func Test_Comment_Validation(t *testing.T) {
+	expect(t, "合法的注释", ValidComment(" a - b ") && ValidComment(""))
+	expect(t, "含有--", !ValidComment("a--b"))
+	expect(t, "以-结尾", !ValidComment("a-"))
+
+	comment, err := NewCommentE("a--b")
+	expect(t, "NewCommentE", (nil == comment) && (nil != err))
+
+	comment, err = NewCommentE("ok")
+	expect(t, "NewCommentE合法", (nil == err) && (nil != comment.SetCommentE("bad-")) && ("ok" == comment.Comment()))
+
+	root := NewElement("root")
+	root.InsertEndChild(NewComment("a--b---c-"))
+	expect(t, "缺省原样输出", `<root><!--a--b---c---></root>` == NodeToString(root, PrintOptions{}))
+
+	out := NodeToString(root, PrintOptions{SanitizeComments: true})
+	_, err = LoadDocument(strings.NewReader(out))
+	expect(t, "修正之后可以解析", (`<root><!--a- -b- - -c- --></root>` == out) && (nil == err))
+}
+
+func Test_Load_DuplicateAttributePolicy(t *testing.T) {
+	xml := `<root a="1" b="2" a="3"/>`
+	_, err := LoadDocument(strings.NewReader(xml))
+	expect(t, "缺省解析失败", nil != err)
+
+	doc, err := LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{DuplicateAttributePolicy: DuplicateAttributeKeepFirst})
+	expect(t, "保留第一个", (nil == err) && (`<root a="1" b="2"/>` == NodeToString(doc, PrintOptions{})))
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(xml), LoadOptions{DuplicateAttributePolicy: DuplicateAttributeKeepLast})
+	expect(t, "保留最后一个", (nil == err) && (`<root a="3" b="2"/>` == NodeToString(doc, PrintOptions{})))
+}
+
+func Test_Load_AllowFragment(t *testing.T) {
+	options := LoadOptions{AllowFragment: true}
+	doc, err := LoadDocumentWithOptions(strings.NewReader(`<a>1</a>text<!--c--><b/>`), options)
+	expect(t, "多个顶层元素", (nil == err) && (4 == doc.CountChildren()) && (`<a>1</a>text<!--c--><b/>` == NodeToString(doc, PrintOptions{})))
+
+	doc, err = LoadDocumentWithOptions(strings.NewReader(""), options)
+	expect(t, "空文档", (nil == err) && doc.NoChildren())
+
+	_, err = LoadDocumentWithOptions(strings.NewReader(`<a>`), options)
+	expect(t, "元素没有关闭", nil != err)
+
+	_, err = LoadDocument(strings.NewReader(`<a/><b/>`))
+	expect(t, "缺省只允许一个根元素", nil != err)
+}
+
+func Test_InsertSibling(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a/><c/></root>`))
+	a := doc.RootElement().FirstChildElement("a")
+
+	node, err := InsertSiblingAfter(a, NewElement("b"))
+	expect(t, "插入到之后", (nil == err) && (nil != node) && (`<root><a/><b/><c/></root>` == NodeToString(doc, PrintOptions{})))
+
+	node, err = InsertSiblingBefore(a, NewComment("first"))
+	expect(t, "插入到之前", (nil == err) && (nil != node) && (`<root><!--first--><a/><b/><c/></root>` == NodeToString(doc, PrintOptions{})))
+
+	_, err = InsertSiblingAfter(NewElement("detached"), NewElement("x"))
+	expect(t, "游离的节点", nil != err)
+
+	_, err = InsertSiblingBefore(doc.RootElement(), NewDocument())
+	expect(t, "插入文档", nil != err)
+
+	_, err = InsertSiblingAfter(a, doc.RootElement())
+	expect(t, "插入祖先节点", nil != err)
+
+	_, err = InsertSiblingAfter(a, a)
+	expect(t, "插入自己", nil != err)
+
+	_, err = InsertSiblingAfter(nil, a)
+	expect(t, "nil", nil != err)
+	expect(t, "失败时文档不变", `<root><!--first--><a/><b/><c/></root>` == NodeToString(doc, PrintOptions{}))
+}
+
+func Test_XMLSpacePreserve(t *testing.T) {
+	src := "<root>\n  <pre xml:space=\"preserve\">\n    <b>x</b>\n    <i> </i>\n  </pre>\n  <p><b>x</b>\n  </p>\n</root>"
+	doc, _ := LoadDocument(strings.NewReader(src))
+	pre := doc.RootElement().FirstChildElement("pre")
+	expect(t, "加载时保留空白", (5 == pre.CountChildren()) && (" " == pre.FirstChildElement("i").Text()))
+	expect(t, "范围之外仍然丢弃缩进", 1 == doc.RootElement().FirstChildElement("p").CountChildren())
+
+	expected := "<root>\n  <pre xml:space=\"preserve\">\n    <b>x</b>\n    <i> </i>\n  </pre>\n  <p>\n    <b>x</b>\n  </p>\n</root>"
+	expect(t, "输出时不重新排版", expected == NodeToString(doc, PrintOptions{Indent: []byte("  ")}))
+
+	doc, _ = LoadDocument(strings.NewReader("<root xml:space=\"preserve\"><a xml:space=\"default\">\n  <b/>\n</a></root>"))
+	expect(t, "xml:space=default", 1 == doc.RootElement().FirstChildElement("a").CountChildren())
+
+	buf := bytes.NewBufferString("")
+	w := NewStreamWriter(buf, PrintOptions{Indent: []byte("  ")})
+	w.StartElement("root")
+	w.StartElement("pre")
+	w.Attr("xml:space", "preserve")
+	w.StartElement("b")
+	w.EndElement()
+	w.Close()
+	expect(t, "流式输出", "<root>\n  <pre xml:space=\"preserve\"><b/></pre>\n</root>" == buf.String())
+}
+
+func Test_Node_Sibling(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><!--about a--><?pi?><a/>text<b/><!--end--></root>`))
+	a := doc.RootElement().FirstChildElement("a")
+
+	comment := a.PrevSibling(CommentNode)
+	expect(t, "前面最近的注释", (nil != comment) && ("about a" == comment.Value()))
+	expect(t, "后面最近的文本", "text" == a.NextSibling(TextNode).Value())
+	expect(t, "后面最近的注释", "end" == a.NextSibling(CommentNode).Value())
+	expect(t, "后面最近的元素", "b" == a.NextSibling(ElementNode).Value())
+	expect(t, "找不到", (nil == a.PrevSibling(ElementNode)) && (nil == a.NextSibling(DirectiveNode)))
+	expect(t, "没有父节点", nil == NewElement("x").NextSibling(ElementNode))
+}
+
+func Test_Hash(t *testing.T) {
+	load := func(xml string) XMLNode {
+		doc, _ := LoadDocument(strings.NewReader(xml))
+		return doc.RootElement()
+	}
+
+	a := load(`<r x="1" y="2"><a>text</a><!--c--><?pi v?></r>`)
+	expect(t, "相同的内容", Hash(a) == Hash(load(`<r x="1" y="2"><a>text</a><!--c--><?pi v?></r>`)))
+	expect(t, "属性的顺序不影响", Hash(a) == Hash(load(`<r y="2" x="1"><a>text</a><!--c--><?pi v?></r>`)))
+	expect(t, "复制整个文档", Hash(a.Document()) == Hash(CloneDocument(a.Document())))
+
+	for _, other := range []string{
+		`<r x="1" y="3"><a>text</a><!--c--><?pi v?></r>`,
+		`<r x="1" y="2"><a>text2</a><!--c--><?pi v?></r>`,
+		`<r x="1" y="2"><a>text</a><!--d--><?pi v?></r>`,
+		`<r x="1" y="2"><a>text</a><?pi v?></r>`,
+		`<r x="1" y="2"><a><b/></a><!--c--><?pi v?></r>`,
+		`<s x="1" y="2"><a>text</a><!--c--><?pi v?></s>`,
+	} {
+		expect(t, "不同的内容:"+other, Hash(a) != Hash(load(other)))
+	}
+
+	text := NewText("x")
+	cdata := NewText("x")
+	cdata.SetCDATA(true)
+	expect(t, "CDATA标记参与计算", Hash(text) != Hash(cdata))
+	expect(t, "结构不同", Hash(load(`<r><a><b/></a><c/></r>`)) != Hash(load(`<r><a><b/><c/></a></r>`)))
+	expect(t, "nil", 0 == Hash(nil))
+}
+
+func Test_Print_TrimText(t *testing.T) {
+	root := NewElement("root")
+	root.InsertElementEndChild("a").SetText("  a & b \n")
+	root.InsertElementEndChild("b").SetCDATAText("  cdata  ")
+
+	expect(t, "去掉首尾的空白", `<root><a>a &amp; b</a><b><![CDATA[  cdata  ]]></b></root>` == NodeToString(root, PrintOptions{TrimText: true}))
+	expect(t, "不修改DOM树", "  a & b \n" == root.FirstChildElement("a").Text())
+	expect(t, "缺省不去掉", `<root><a>  a &amp; b `+"\n"+`</a><b><![CDATA[  cdata  ]]></b></root>` == NodeToString(root, PrintOptions{}))
+}
+
+func Test_Element_TextAs(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader("<r><count>\n  42\n</count><price>3.5</price><flag>true</flag><zero>0</zero><bad>4x</bad><empty/></r>"))
+	root := doc.RootElement()
+
+	n, err := root.FirstChildElement("count").TextAsInt()
+	expect(t, "整数,忽略首尾空白", (nil == err) && (42 == n))
+
+	f, err := root.FirstChildElement("price").TextAsFloat()
+	expect(t, "浮点数", (nil == err) && (3.5 == f))
+
+	b, err := root.FirstChildElement("flag").TextAsBool()
+	expect(t, "布尔值", (nil == err) && b)
+
+	b, err = root.FirstChildElement("zero").TextAsBool()
+	expect(t, "0是false", (nil == err) && !b)
+
+	_, err = root.FirstChildElement("bad").TextAsInt()
+	expect(t, "不合法的整数", (nil != err) && strings.Contains(err.Error(), "<bad>"))
+
+	_, err = root.FirstChildElement("bad").TextAsFloat()
+	expect(t, "不合法的浮点数", nil != err)
+
+	_, err = root.FirstChildElement("empty").TextAsBool()
+	expect(t, "没有内容", nil != err)
+}
+
+func Test_PruneEmpty(t *testing.T) {
+	xml := `<root><a><b><c/></b><!--only comment--></a><d>text</d><e flag="1"/><f><g flag="1"> </g></f></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	expect(t, "删除所有的空元素", (6 == PruneEmpty(doc)) && (`<root><d>text</d></root>` == NodeToString(doc, PrintOptions{})))
+
+	doc, _ = LoadDocument(strings.NewReader(xml))
+	count := PruneEmptyWithOptions(doc, PruneOptions{KeepAttributed: true})
+	expect(t, "保留带有属性的空元素", (3 == count) && (`<root><d>text</d><e flag="1"/><f><g flag="1"/></f></root>` == NodeToString(doc, PrintOptions{})))
+
+	root := NewElement("root")
+	expect(t, "根节点不会被删除", (0 == PruneEmpty(root)) && (0 == PruneEmpty(nil)))
+}
+
+func Test_NamespacesInScope(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root xmlns="urn:default" xmlns:a="urn:a1" xmlns:b="urn:b">` +
+		`<mid xmlns:a="urn:a2"><leaf xmlns:c="urn:c"/></mid><plain xmlns=""/></root>`))
+	leaf := doc.RootElement().FirstChildElement("mid").FirstChildElement("leaf")
+
+	ns := NamespacesInScope(leaf)
+	expect(t, "收集所有祖先上的声明", 5 == len(ns))
+	expect(t, "近处的声明覆盖远处的", ("urn:a2" == ns["a"]) && ("urn:b" == ns["b"]) && ("urn:c" == ns["c"]))
+	expect(t, "缺省名字空间", "urn:default" == ns[""])
+	expect(t, "xml前缀", xmlNamespaceURI == ns["xml"])
+
+	ns = NamespacesInScope(doc.RootElement().FirstChildElement("plain"))
+	_, ok := ns[""]
+	expect(t, "取消缺省名字空间", !ok && ("urn:a1" == ns["a"]))
+	expect(t, "nil", nil == NamespacesInScope(nil))
+}
+
+func Test_Print_NewlineIndentUnit(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><a><b/></a></root>`))
+
+	expect(t, "不折行", `<root><a><b/></a></root>` == NodeToString(doc, PrintOptions{IndentUnit: []byte("  ")}))
+	expect(t, "折行但不缩进", "<root>\n<a>\n<b/>\n</a>\n</root>" == NodeToString(doc, PrintOptions{Newline: true}))
+	expect(t, "折行并缩进", "<root>\n\t<a>\n\t\t<b/>\n\t</a>\n</root>" == NodeToString(doc, PrintOptions{Newline: true, IndentUnit: []byte("\t")}))
+	expect(t, "Newline优先于Indent", "<root>\n<a>\n<b/>\n</a>\n</root>" == NodeToString(doc, PrintOptions{Newline: true, Indent: []byte("    ")}))
+	expect(t, "兼容原有的Indent", NodeToString(doc, PrintOptions{Indent: []byte("  ")}) == NodeToString(doc, PrintOptions{Newline: true, IndentUnit: []byte("  ")}))
+
+	buf := bytes.NewBufferString("")
+	w := NewStreamWriter(buf, PrintOptions{Newline: true, IndentUnit: []byte(" ")})
+	w.StartElement("a")
+	w.StartElement("b")
+	w.Close()
+	expect(t, "流式输出", "<a>\n <b/>\n</a>" == buf.String())
+}
+
+func Test_AllComments(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<?xml version="1.0"?><!--head--><!DOCTYPE root><root><!--a--><x><?pi data?><!--b--></x></root><?tail?>`))
+
+	comments := AllComments(doc)
+	expect(t, "所有的注释", (3 == len(comments)) && ("head" == comments[0].Comment()) && ("b" == comments[2].Comment()))
+	expect(t, "注释的位置", "/root/x/comment()[1]" == comments[2].Path())
+
+	procInsts := AllProcInsts(doc)
+	expect(t, "所有的处理指令", (3 == len(procInsts)) && ("xml" == procInsts[0].Target()) && ("tail" == procInsts[2].Target()))
+
+	directives := AllDirectives(doc)
+	expect(t, "所有的指令", (1 == len(directives)) && ("DOCTYPE root" == directives[0].Value()))
+
+	expect(t, "子树", 1 == len(AllComments(doc.RootElement().FirstChildElement("x"))))
+	expect(t, "nil", (0 == len(AllComments(nil))) && (0 == len(AllDirectives(nil))) && (0 == len(AllProcInsts(nil))))
+}
+
+func Test_ReplaceTextFunc(t *testing.T) {
+	root := NewElement("root")
+	root.SetAttribute("title", "{{name}}")
+	root.InsertElementEndChild("a").SetText("Hello {{name}}")
+	root.InsertElementEndChild("b").SetCDATAText("{{name}} & co")
+
+	replacer := strings.NewReplacer("{{name}}", "Tom")
+	ReplaceTextFunc(root, replacer.Replace)
+	expect(t, "替换文本,CDATA标记不变", `<root title="{{name}}"><a>Hello Tom</a><b><![CDATA[Tom & co]]></b></root>` == NodeToString(root, PrintOptions{}))
+
+	ReplaceAttributeValues(root, replacer.Replace)
+	expect(t, "替换属性值", "Tom" == root.Attribute("title", ""))
+
+	ReplaceTextFunc(nil, replacer.Replace)
+	ReplaceAttributeValues(nil, replacer.Replace)
+}
+
+func Test_Print_PreserveSelfClose(t *testing.T) {
+	xml := `<root><a/><b></b><c>  </c><d x="1"/></root>`
+	doc, _ := LoadDocument(strings.NewReader(xml))
+	doc.RootElement().InsertElementEndChild("new")
+
+	options := PrintOptions{PreserveSelfClose: true}
+	expect(t, "保持原来的形式", `<root><a/><b></b><c></c><d x="1"/><new/></root>` == NodeToString(doc, options))
+	expect(t, "缺省全部自闭合", `<root><a/><b/><c/><d x="1"/><new/></root>` == NodeToString(doc, PrintOptions{}))
+
+	options.NoSelfClose = true
+	expect(t, "程序创建的元素按照NoSelfClose输出", `<root><a/><b></b><c></c><d x="1"/><new></new></root>` == NodeToString(doc, options))
+
+	expect(t, "复制之后仍然保持", `<root><a/><b></b><c></c><d x="1"/><new></new></root>` == NodeToString(CloneDocument(doc), options))
+
+	doc, _ = LoadDocument(strings.NewReader(`<root><a/><b></b></root>`))
+	pretty := PrintOptions{Indent: []byte("  "), PreserveSelfClose: true}
+	expect(t, "缩进输出", "<root>\n  <a/>\n  <b></b>\n</root>" == NodeToString(doc, pretty))
+}
+
+func Test_EscapeAttribute_Tab(t *testing.T) {
+	buf := bytes.NewBufferString("")
+	EscapeAttribute(buf, []byte("a\tb"))
+	expect(t, "tab转义成&#x9;", "a&#x9;b" == buf.String())
+
+	buf.Reset()
+	EscapeText(buf, []byte("a\tb"))
+	expect(t, "文本中的tab不转义", "a\tb" == buf.String())
+
+	elem := NewElement("e")
+	elem.SetAttribute("v", "1\t2")
+	for _, options := range []PrintOptions{{}, {XML11: true}, {AttributeQuote: '\''}, {AttributeEscaper: EscapeASCII}} {
+		out := NodeToString(elem, options)
+		doc, err := LoadDocument(strings.NewReader(out))
+		expect(t, "重新解析之后tab仍然保留:"+out, (nil == err) && ("1\t2" == doc.RootElement().Attribute("v", "")))
+	}
+}
+
+func Test_Element_TextSegments(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<p>Hello <b>big</b> world<!--c-->!</p>`))
+	segments := doc.RootElement().TextSegments()
+	expect(t, "每段文本", fmt.Sprintf("%q", []string{"Hello ", " world", "!"}) == fmt.Sprintf("%q", segments))
+	expect(t, "没有文本", 0 == len(NewElement("e").TextSegments()))
+}
+
+func Test_Print_OnInvalidChar(t *testing.T) {
+	root := NewElement("root")
+	root.SetAttribute("a", "x\x01y")
+	root.SetText("t\x00e\xffxt")
+
+	var found []rune
+	options := PrintOptions{OnInvalidChar: func(r rune) error {
+		found = append(found, r)
+		return nil
+	}}
+	out := NodeToString(root, options)
+	expect(t, "仍然替换成U+FFFD", "<root a=\"x�y\">t�e�xt</root>" == out)
+	expect(t, "每个字符都会通知", fmt.Sprint([]rune{0x01, 0x00, 0xFFFD}) == fmt.Sprint(found))
+
+	options.OnInvalidChar = func(r rune) error {
+		return fmt.Errorf("bad char %U", r)
+	}
+	doc := NewDocument()
+	doc.InsertEndChild(root)
+	err := SaveDocument(doc, ioutil.Discard, options)
+	expect(t, "返回错误时停止输出", (nil != err) && ("bad char U+0001" == err.Error()))
+}
+
+func Test_Evaluate(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><name>shop <b>one</b></name><!--c--><item price="1.5" id="a"><tag>x</tag></item><item price="2"><tag>y</tag></item><group><item price="3" id="b"/></group></root>`))
+	root := doc.RootElement()
+	group := root.FirstChildElement("group")
+
+	v, err := Evaluate(doc, "count(//item)")
+	expect(t, "统计元素个数", (nil == err) && (float64(3) == v))
+
+	v, err = Evaluate(doc, "//item/@price")
+	attrs, ok := v.([]XMLAttribute)
+	expect(t, "属性值", (nil == err) && ok && (3 == len(attrs)))
+	expect(t, "属性按照文档顺序排列", ("1.5" == attrs[0].Value()) && ("2" == attrs[1].Value()) && ("3" == attrs[2].Value()))
+
+	v, err = Evaluate(doc, "string(/root/name)")
+	expect(t, "元素的字符串值", (nil == err) && ("shop one" == v))
+
+	v, err = Evaluate(doc, "number(/root/item[2]/@price)")
+	expect(t, "数字值", (nil == err) && (float64(2) == v))
+	v, err = Evaluate(doc, "number(//tag)")
+	f, _ := v.(float64)
+	expect(t, "不是数字时返回NaN", (nil == err) && math.IsNaN(f))
+
+	v, err = Evaluate(doc, "/root/item[@id='a']/tag")
+	nodes, ok := v.([]XMLNode)
+	expect(t, "按属性值过滤", (nil == err) && ok && (1 == len(nodes)) && ("x" == nodes[0].ToElement().Text()))
+
+	v, _ = Evaluate(doc, "//item[tag='y']")
+	nodes = v.([]XMLNode)
+	expect(t, "按子元素内容过滤", (1 == len(nodes)) && ("2" == nodes[0].ToElement().Attribute("price", "")))
+
+	v, _ = Evaluate(doc, "/root/item[@price][last()]")
+	nodes = v.([]XMLNode)
+	expect(t, "多个谓词依次过滤", (1 == len(nodes)) && ("2" == nodes[0].ToElement().Attribute("price", "")))
+	v, _ = Evaluate(doc, "//item[1]")
+	expect(t, "序号相对于各自的父节点", 2 == len(v.([]XMLNode)))
+
+	v, _ = Evaluate(group, "item/../../name")
+	nodes = v.([]XMLNode)
+	expect(t, "相对路径", (1 == len(nodes)) && ("name" == nodes[0].Value()))
+
+	for _, node := range []XMLNode{group.FirstChildElement("item"), root.FirstChild().Next()} {
+		v, err = Evaluate(doc, node.Path())
+		nodes, _ = v.([]XMLNode)
+		expect(t, "按Path()找回节点:"+node.Path(), (nil == err) && (1 == len(nodes)) && (node == nodes[0]))
+	}
+
+	v, _ = Evaluate(group, "/group/item/@*")
+	expect(t, "文档中的子树从文档开始匹配", 0 == len(v.([]XMLAttribute)))
+	root.DeleteChild(group)
+	v, _ = Evaluate(group, "/group/item/@*")
+	expect(t, "游离子树从最上层的节点开始匹配", 2 == len(v.([]XMLAttribute)))
+
+	for _, expr := range []string{"", "//item[", "/root/@id/name", "//item[contains(., 'x')]", "//item[@id=a]",
+		"sum(//item)", "count(//item) + 1", "//item | //name", "child::item", "count(//item", "//item[child::tag]", "/root/item + 1"} {
+		_, err = Evaluate(doc, expr)
+		expect(t, "不支持的表达式:"+expr, nil != err)
+	}
+}
+
+func Test_Node_InsertCommentAndText(t *testing.T) {
+	doc := NewDocument()
+	root := doc.InsertElementEndChild("root")
+	b := root.InsertElementEndChild("b")
+
+	c := root.InsertCommentFirstChild("first")
+	expect(t, "返回插入的注释", (nil != c) && ("first" == c.Value()))
+	text := root.InsertTextEndChild("end")
+	expect(t, "返回插入的文本", (nil != text) && ("end" == text.Value()))
+
+	b.InsertCommentFront("before")
+	b.InsertCommentBack("after")
+	b.InsertTextFront("x")
+	b.InsertTextBack("y")
+	b.InsertTextFirstChild("in")
+	b.InsertCommentEndChild("last")
+	doc.InsertCommentFirstChild("top")
+	expect(t, "检查插入位置", `<!--top--><root><!--first--><!--before-->x<b>in<!--last--></b>y<!--after-->end</root>` == NodeToString(doc, PrintStream))
+}
+
+func Test_Print_BlankLineBetweenTopLevel(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader("<?xml version=\"1.0\"?><config>\n\n<!--db--><db host=\"h\"><port>1</port></db>\n\n<cache/><log>x</log></config>"))
+
+	options := IndentSpaces(2)
+	options.BlankLineBetweenTopLevel = true
+	expected := "<?xml version=\"1.0\"?>\n<config>\n  <!--db-->\n  <db host=\"h\">\n    <port>1</port>\n  </db>\n\n  <cache/>\n\n  <log>x</log>\n</config>"
+	expect(t, "根元素的子节点之间有空行,注释之后没有", expected == NodeToString(doc, options))
+
+	options.Indent = nil
+	expect(t, "不折行时没有空行", !strings.Contains(NodeToString(doc, options), "\n"))
+}
+
+func Test_Element_ElementIndex(t *testing.T) {
+	doc, _ := LoadDocument(strings.NewReader(`<root><item/>text<other/><!--c--><item/><item id="x"/></root>`))
+	root := doc.RootElement()
+	expect(t, "唯一的元素", (1 == root.ElementIndex()) && (1 == root.FirstChildElement("other").ElementIndex()))
+
+	index := 0
+	for item := root.FirstChildElement("item"); nil != item; item = item.NextElement("item") {
+		index++
+		expect(t, "只计算同名的兄弟元素", index == item.ElementIndex())
+		expect(t, "与Path()一致", fmt.Sprintf("/root/item[%d]", index) == item.Path())
+	}
+	expect(t, "item的个数", 3 == index)
+
+	expect(t, "游离的元素", 1 == NewElement("item").ElementIndex())
+}